@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mathaou/termdbms/database"
+	. "github.com/mathaou/termdbms/viewer"
+)
+
+// OutputTable is the -o format for aligned columns, every other format is one of the :export formats
+const OutputTable = "table"
+
+// -o formats of termdbms diff
+const (
+	DiffOutputText = "text"
+	DiffOutputSQL  = "sql"
+)
+
+// runQuery runs a single statement without starting the UI and prints the result to stdout. "-" reads
+// the statement from stdin. The returned exit code is non-zero if anything went wrong
+func runQuery(fileName string, db *sql.DB, query, format string) int {
+	var exporter Exporter = &tableOutput{}
+	if format != OutputTable {
+		f, ok := GetExportFormat(format)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "ERROR: Invalid output format %s, expected table or one of %s\n", format, GetExportFormatNames())
+			return 2
+		}
+		exporter = f.New()
+	}
+
+	if query == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Could not read query from stdin: %v\n", err)
+			return 1
+		}
+		query = string(b)
+	}
+	if strings.TrimSpace(query) == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: Empty query")
+		return 2
+	}
+
+	d := database.NewDatabase(fileName, db)
+	rows, err := d.GetDatabaseReference().Query(query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 1
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 1
+	}
+
+	if len(columns) == 0 { // update, insert and friends don't return anything to print
+		return 0
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	if err = ExportRows(out, exporter, d, QueryResultsTableName, rows); err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// tableOutput lines up the columns with spaces, for reading in a terminal
+type tableOutput struct {
+	t *tabwriter.Writer
+}
+
+func (o *tableOutput) Begin(w io.Writer, _ database.Database, _ string, columns []ExportColumn) error {
+	o.t = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	names := make([]string, len(columns))
+	dashes := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+		dashes[i] = strings.Repeat("-", len(c.Name))
+	}
+	fmt.Fprintln(o.t, strings.Join(names, "\t"))
+	_, err := fmt.Fprintln(o.t, strings.Join(dashes, "\t"))
+
+	return err
+}
+
+func (o *tableOutput) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(GetStringRepresentationOfExport(v))
+	}
+	_, err := fmt.Fprintln(o.t, strings.Join(record, "\t"))
+
+	return err
+}
+
+func (o *tableOutput) End() error {
+	return o.t.Flush()
+}
+
+// runDiff compares two SQLite files (termdbms diff [-o text|sql] a.db b.db) and prints what changed from the
+// first to the second, as a report or as a SQL script that turns the first into the second. Like diff, the exit
+// code is 1 if they differ and 2 if something went wrong
+func runDiff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := flags.String("o", DiffOutputText, "Output format of diff (text, sql).")
+	var files []string
+	for { // flags can come before, between or after the files
+		if err := flags.Parse(args); err != nil {
+			return 2
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		files = append(files, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	if len(files) != 2 {
+		fmt.Fprintln(os.Stderr, "ERROR: Expected two SQLite files, termdbms diff [-o text|sql] a.db b.db")
+		return 2
+	}
+	if *format != DiffOutputText && *format != DiffOutputSQL {
+		fmt.Fprintf(os.Stderr, "ERROR: Invalid output format %s, expected %s or %s\n", *format, DiffOutputText, DiffOutputSQL)
+		return 2
+	}
+	for _, f := range files {
+		if info, err := os.Stat(f); err != nil || !info.Mode().IsRegular() {
+			fmt.Fprintf(os.Stderr, "ERROR: %s is not a file\n", f)
+			return 2
+		}
+	}
+
+	db, err := sql.Open(database.DriverSQLite, files[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1) // the first file is attached to the connection
+
+	changes, err := database.DiffSQLite(db, files[0], nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	if *format == DiffOutputSQL {
+		err = database.WriteSQLitePatch(out, db, changes)
+	} else {
+		err = WriteChanges(out, files[0], files[1], changes)
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
+
+	if len(changes) > 0 {
+		return 1
+	}
+
+	return 0
+}
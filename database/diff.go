@@ -0,0 +1,422 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// kinds of Change
+const (
+	ChangeUpdate = "update" // a cell of a row that's in both databases
+	ChangeInsert = "insert" // a row that's only in the new database
+	ChangeDelete = "delete" // a row that's only in the base database
+	ChangeSchema = "schema" // a table, index, view or trigger that was created, dropped or altered
+)
+
+// DiffSchemaName is what the base database is attached as while it's compared
+const DiffSchemaName = "termdbms_base"
+
+// Change is one difference between a base SQLite database and a newer one
+type Change struct {
+	Kind         string
+	Table        string        // the table of the change, or the one an index or trigger belongs to
+	Object       string        // the table, index, view or trigger of a schema change
+	ObjectType   string        // table, index, view or trigger
+	Statement    string        // the CREATE statement of the object in the new database, empty if it was dropped
+	OldStatement string        // the CREATE statement of the object in the base database, empty if it was created
+	KeyColumns   []string      // what rows are matched by, the primary key or rowid
+	Key          []interface{} // the values of KeyColumns for the changed row
+	Column       string        // the column of an updated cell
+	Old          interface{}   // the updated cell in the base database
+	New          interface{}   // the updated cell in the new database
+	Columns      []string      // the columns of an inserted or deleted row, or those an altered table kept
+	Row          []interface{} // the values of an inserted or deleted row
+}
+
+// GetSchemaChangeVerb is what happened to the object of a schema change: create, drop or alter
+func (c Change) GetSchemaChangeVerb() string {
+	switch {
+	case c.OldStatement == "":
+		return "create"
+	case c.Statement == "":
+		return "drop"
+	}
+
+	return "alter"
+}
+
+// schemaObject is a table, index, view or trigger as it's declared in one of the databases
+type schemaObject struct {
+	Type    string
+	Table   string
+	SQL     string
+	Columns []string // tables only
+	Keys    []string
+}
+
+// DiffSQLite lists what changed from the database in the base file to the one db is connected to. Rows are
+// matched by primary key, or rowid for tables without one (or whose primary key changed). Values are read as
+// they're stored, without the driver turning dates into times. Each table gets its schema changes first, then
+// deleted, inserted and updated rows. Only the rows of tables in only are compared, unless it's nil, but new
+// tables always have theirs listed
+func DiffSQLite(db *sql.DB, base string, only map[string]bool) ([]Change, error) {
+	if _, err := db.Exec(fmt.Sprintf("ATTACH DATABASE ? AS %s", DiffSchemaName), base); err != nil {
+		return nil, err
+	}
+	defer db.Exec(fmt.Sprintf("DETACH DATABASE %s", DiffSchemaName))
+
+	newObjects, err := getSchemaObjects(db, "main")
+	if err != nil {
+		return nil, err
+	}
+	baseObjects, err := getSchemaObjects(db, DiffSchemaName)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := map[string]bool{}
+	for _, objects := range []map[string]schemaObject{newObjects, baseObjects} {
+		for _, o := range objects {
+			tables[o.Table] = true
+		}
+	}
+	var names []string
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var changes []Change
+	for _, table := range names {
+		newTable, inNew := newObjects[table]
+		baseTable, inBase := baseObjects[table]
+		if newTable.Type == "table" || baseTable.Type == "table" {
+			if change, changed := diffSchemaObject(table, newTable, baseTable, inNew, inBase); changed {
+				if inNew && inBase {
+					change.Columns = commonColumns(newTable.Columns, baseTable.Columns)
+				}
+				changes = append(changes, change)
+			}
+		}
+		changes = append(changes, diffSchemaObjects(table, newObjects, baseObjects)...)
+
+		if newTable.Type != "table" || (inBase && baseTable.Type != "table") || (inBase && only != nil && !only[table]) {
+			continue
+		}
+		rows, err := diffRows(db, table, newTable, baseTable, inBase)
+		if err != nil {
+			return changes, fmt.Errorf("could not compare %s: %v", table, err)
+		}
+		changes = append(changes, rows...)
+	}
+
+	return changes, nil
+}
+
+// diffSchemaObject is the change to an object, if there is one
+func diffSchemaObject(name string, newObject, baseObject schemaObject, inNew, inBase bool) (Change, bool) {
+	change := Change{
+		Kind:         ChangeSchema,
+		Table:        newObject.Table,
+		Object:       name,
+		ObjectType:   newObject.Type,
+		Statement:    newObject.SQL,
+		OldStatement: baseObject.SQL,
+	}
+	if !inNew {
+		change.Table, change.ObjectType = baseObject.Table, baseObject.Type
+	}
+
+	return change, !inNew || !inBase || newObject.SQL != baseObject.SQL || newObject.Type != baseObject.Type
+}
+
+// diffSchemaObjects lists the changed indexes, views and triggers that belong to a table, sorted by name.
+// Views belong to themselves
+func diffSchemaObjects(table string, newObjects, baseObjects map[string]schemaObject) []Change {
+	var names []string
+	for _, objects := range []map[string]schemaObject{newObjects, baseObjects} {
+		for name, o := range objects {
+			if o.Table == table && o.Type != "table" && (o.Type != "view" || name != table) && indexOfString(names, name) < 0 {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	if o, ok := newObjects[table]; ok && o.Type == "view" {
+		names = append([]string{table}, names...)
+	} else if o, ok := baseObjects[table]; ok && o.Type == "view" {
+		names = append([]string{table}, names...)
+	}
+
+	var changes []Change
+	for _, name := range names {
+		newObject, inNew := newObjects[name]
+		baseObject, inBase := baseObjects[name]
+		if newObject.Type == "table" || baseObject.Type == "table" {
+			continue
+		}
+		if change, changed := diffSchemaObject(name, newObject, baseObject, inNew, inBase); changed {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes
+}
+
+// getSchemaObjects reads the schema of one of the attached databases. Internal and automatic indexes are left out
+func getSchemaObjects(db *sql.DB, schema string) (map[string]schemaObject, error) {
+	objects := map[string]schemaObject{}
+	rows, err := db.Query(fmt.Sprintf("SELECT type, name, tbl_name, sql FROM %s.sqlite_master "+
+		"WHERE type IN ('table', 'index', 'view', 'trigger') AND name NOT LIKE 'sqlite_%%' AND sql IS NOT NULL", schema))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var o schemaObject
+		var name string
+		rows.Scan(&o.Type, &name, &o.Table, &o.SQL)
+		objects[name] = o
+	}
+	rows.Close()
+
+	for name, o := range objects {
+		if o.Type != "table" {
+			continue
+		}
+		rows, err = db.Query("SELECT name, pk FROM pragma_table_info(?, ?) ORDER BY cid", name, schema)
+		if err != nil {
+			return nil, err
+		}
+		keys := map[int]string{}
+		for rows.Next() {
+			var (
+				column string
+				pk     int
+			)
+			rows.Scan(&column, &pk)
+			o.Columns = append(o.Columns, column)
+			if pk > 0 {
+				keys[pk] = column
+			}
+		}
+		rows.Close()
+		for i := 1; i <= len(keys); i++ {
+			o.Keys = append(o.Keys, keys[i])
+		}
+		objects[name] = o
+	}
+
+	return objects, nil
+}
+
+// commonColumns are the columns of a table that both versions of it have, in the new order
+func commonColumns(newColumns, baseColumns []string) []string {
+	var common []string
+	for _, c := range newColumns {
+		if indexOfString(baseColumns, c) >= 0 {
+			common = append(common, c)
+		}
+	}
+
+	return common
+}
+
+func indexOfString(slice []string, s string) int {
+	for i, v := range slice {
+		if v == s {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// diffRows compares the rows of a table that's in both databases, or lists them all as inserted if it's new
+func diffRows(db *sql.DB, name string, newTable, baseTable schemaObject, inBase bool) ([]Change, error) {
+	quote := SQLite{}.QuoteIdentifier
+	newName := "main." + quote(name)
+	baseName := DiffSchemaName + "." + quote(name)
+
+	keys := newTable.Keys
+	if len(keys) == 0 || strings.Join(keys, ",") != strings.Join(baseTable.Keys, ",") {
+		keys = []string{"rowid"}
+	}
+	keyExpressions := make([]string, len(keys))
+	join := make([]string, len(keys))
+	for i, k := range keys {
+		if k != "rowid" {
+			k = quote(k)
+		}
+		keyExpressions[i] = k
+		join[i] = fmt.Sprintf("n.%s = b.%s", k, k)
+	}
+
+	var changes []Change
+	if !inBase {
+		return changes, scanDiffRows(db, fmt.Sprintf("SELECT %s FROM %s", selectList("", keyExpressions, newTable.Columns), newName),
+			len(keys), func(key, values []interface{}) {
+				changes = append(changes, Change{Kind: ChangeInsert, Table: name, KeyColumns: keys, Key: key,
+					Columns: newTable.Columns, Row: values})
+			})
+	}
+
+	err := scanDiffRows(db, fmt.Sprintf("SELECT %s FROM %s AS b WHERE NOT EXISTS (SELECT 1 FROM %s AS n WHERE %s)",
+		selectList("b.", keyExpressions, baseTable.Columns), baseName, newName, strings.Join(join, " AND ")),
+		len(keys), func(key, values []interface{}) {
+			changes = append(changes, Change{Kind: ChangeDelete, Table: name, KeyColumns: keys, Key: key,
+				Columns: baseTable.Columns, Row: values})
+		})
+	if err != nil {
+		return changes, err
+	}
+
+	err = scanDiffRows(db, fmt.Sprintf("SELECT %s FROM %s AS n WHERE NOT EXISTS (SELECT 1 FROM %s AS b WHERE %s)",
+		selectList("n.", keyExpressions, newTable.Columns), newName, baseName, strings.Join(join, " AND ")),
+		len(keys), func(key, values []interface{}) {
+			changes = append(changes, Change{Kind: ChangeInsert, Table: name, KeyColumns: keys, Key: key,
+				Columns: newTable.Columns, Row: values})
+		})
+	if err != nil {
+		return changes, err
+	}
+
+	// every cell of the new table, columns the base table doesn't have count as NULL there
+	var (
+		pairs     []string
+		different []string
+	)
+	for _, c := range newTable.Columns {
+		baseValue := "b." + quote(c)
+		if indexOfString(baseTable.Columns, c) < 0 {
+			baseValue = "NULL"
+		}
+		differs := fmt.Sprintf("n.%s IS NOT %s", quote(c), baseValue)
+		pairs = append(pairs, fmt.Sprintf("+n.%s, +%s, %s", quote(c), baseValue, differs))
+		different = append(different, differs)
+	}
+	if len(pairs) == 0 {
+		return changes, nil
+	}
+
+	err = scanDiffRows(db, fmt.Sprintf("SELECT %s, %s FROM %s AS n JOIN %s AS b ON %s WHERE %s",
+		selectList("n.", keyExpressions, nil), strings.Join(pairs, ", "), newName, baseName,
+		strings.Join(join, " AND "), strings.Join(different, " OR ")),
+		len(keys), func(key, values []interface{}) {
+			for i, c := range newTable.Columns {
+				newValue, baseValue, differs := values[i*3], values[i*3+1], values[i*3+2]
+				if differs != int64(1) {
+					continue
+				}
+				changes = append(changes, Change{Kind: ChangeUpdate, Table: name, KeyColumns: keys, Key: key,
+					Column: c, Old: baseValue, New: newValue})
+			}
+		})
+
+	return changes, err
+}
+
+// selectList is the key expressions followed by the columns, all from the table aliased by prefix. The values
+// are selected with a unary +, which leaves them as they are but drops the declared type of the column
+func selectList(prefix string, keys, columns []string) string {
+	var list []string
+	for _, k := range keys {
+		list = append(list, "+"+prefix+k)
+	}
+	for _, c := range columns {
+		list = append(list, "+"+prefix+SQLite{}.QuoteIdentifier(c))
+	}
+
+	return strings.Join(list, ", ")
+}
+
+// columnList is the quoted columns of an inserted or deleted row, after rowid if that's what it's matched by
+func columnList(c Change) string {
+	var columns []string
+	if len(c.KeyColumns) == 1 && c.KeyColumns[0] == "rowid" {
+		columns = append(columns, "rowid")
+	}
+	for _, column := range c.Columns {
+		columns = append(columns, SQLite{}.QuoteIdentifier(column))
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+// scanDiffRows calls found with the key and the rest of the values of every row of a query
+func scanDiffRows(db *sql.DB, query string, keys int, found func(key, values []interface{})) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+
+	var results [][]interface{}
+	columns, _ := rows.Columns()
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			rows.Close()
+			return err
+		}
+		results = append(results, values)
+	}
+	err = rows.Err()
+	rows.Close() // the connection is free again before found runs
+
+	for _, values := range results {
+		found(values[:keys], values[keys:])
+	}
+
+	return err
+}
+
+// RevertChange puts the rows of a change back the way they are in the base file. Schema changes can't be
+// reverted on their own
+func RevertChange(db *sql.DB, base string, c Change) error {
+	if c.Kind == ChangeSchema {
+		return fmt.Errorf("schema changes to %s can't be reverted one at a time", c.Table)
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("ATTACH DATABASE ? AS %s", DiffSchemaName), base); err != nil {
+		return err
+	}
+	defer db.Exec(fmt.Sprintf("DETACH DATABASE %s", DiffSchemaName))
+
+	quote := SQLite{}.QuoteIdentifier
+	newName := "main." + quote(c.Table)
+	baseName := DiffSchemaName + "." + quote(c.Table)
+
+	var conditions []string
+	for _, k := range c.KeyColumns {
+		if k != "rowid" {
+			k = quote(k)
+		}
+		conditions = append(conditions, k+" = ?")
+	}
+	where := strings.Join(conditions, " AND ")
+
+	var (
+		statement string
+		args      = c.Key
+	)
+	switch c.Kind {
+	case ChangeUpdate:
+		statement = fmt.Sprintf("UPDATE %s SET %s = (SELECT %s FROM %s WHERE %s) WHERE %s",
+			newName, quote(c.Column), quote(c.Column), baseName, where, where)
+		args = append(append([]interface{}{}, c.Key...), c.Key...)
+	case ChangeInsert:
+		statement = fmt.Sprintf("DELETE FROM %s WHERE %s", newName, where)
+	case ChangeDelete:
+		columns := columnList(c)
+		statement = fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE %s", newName, columns, columns, baseName, where)
+	}
+
+	_, err := db.Exec(statement, args...)
+
+	return err
+}
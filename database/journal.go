@@ -0,0 +1,412 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+const journalTableName = "termdbms_undolog"
+
+// JournalStep is one undoable edit, the range of log entries it produced
+type JournalStep struct {
+	Begin int64
+	End   int64
+}
+
+// Journal records the inverse SQL of every change made to a SQLite database, one step per edit. Temp triggers on
+// every table write the inverse statements into a temp table as changes happen, so cell edits and raw
+// update/delete/insert statements are journaled the same way. Undoing a step runs its inverse statements, which
+// fire the triggers again and leave behind the statements to redo it. See https://www.sqlite.org/undoredo.html
+//
+// Temp objects only exist on the connection that made them, so the database has to be limited to one connection.
+type Journal struct {
+	Database  Database
+	UndoStack []JournalStep
+	RedoStack []JournalStep
+}
+
+// NewJournal creates the undo log for db and installs the triggers for every table
+func NewJournal(db Database) (*Journal, error) {
+	j := &Journal{
+		Database: db,
+	}
+	_, err := db.GetDatabaseReference().Exec(
+		fmt.Sprintf("CREATE TEMP TABLE IF NOT EXISTS %s(seq INTEGER PRIMARY KEY, tbl TEXT, sql TEXT, key TEXT, cols TEXT)",
+			journalTableName))
+	if err != nil {
+		return nil, err
+	}
+
+	return j, j.Refresh()
+}
+
+// Refresh reinstalls the triggers, which is needed whenever a table gets created or altered
+func (j *Journal) Refresh() error {
+	var tables []string
+
+	db := j.Database.GetDatabaseReference()
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	for _, table := range tables {
+		statements, err := j.getTriggers(table)
+		if err != nil {
+			return err
+		}
+		for _, statement := range statements {
+			if _, err = db.Exec(statement); err != nil && !strings.Contains(err.Error(), "virtual table") {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Record runs edit as a single undoable step. Anything that was undone can't be redone afterwards
+func (j *Journal) Record(edit func() error) error {
+	db := j.Database.GetDatabaseReference()
+	begin, err := j.getLastSequence(db)
+	if err != nil {
+		return err
+	}
+
+	editErr := edit()
+
+	end, err := j.getLastSequence(db)
+	if err != nil {
+		return err
+	}
+	if end > begin {
+		j.UndoStack = append(j.UndoStack, JournalStep{
+			Begin: begin + 1,
+			End:   end,
+		})
+		for _, step := range j.RedoStack { // the redo history no longer applies
+			db.Exec(fmt.Sprintf("DELETE FROM temp.%s WHERE seq BETWEEN ? AND ?", journalTableName), step.Begin, step.End)
+		}
+		j.RedoStack = nil
+	}
+
+	return editErr
+}
+
+// Undo reverts the most recent step and makes it available to Redo
+func (j *Journal) Undo() error {
+	if len(j.UndoStack) == 0 {
+		return nil
+	}
+
+	step, err := j.replay(j.UndoStack[len(j.UndoStack)-1])
+	if err != nil {
+		return err
+	}
+	j.UndoStack = j.UndoStack[0 : len(j.UndoStack)-1] // pop
+	j.RedoStack = append(j.RedoStack, step)
+
+	return nil
+}
+
+// Redo applies the most recently undone step again
+func (j *Journal) Redo() error {
+	if len(j.RedoStack) == 0 {
+		return nil
+	}
+
+	step, err := j.replay(j.RedoStack[len(j.RedoStack)-1])
+	if err != nil {
+		return err
+	}
+	j.RedoStack = j.RedoStack[0 : len(j.RedoStack)-1] // pop
+	j.UndoStack = append(j.UndoStack, step)
+
+	return nil
+}
+
+// replay runs the statements of a step newest first, returning the step its triggers recorded in turn
+func (j *Journal) replay(step JournalStep) (JournalStep, error) {
+	var statements []string
+
+	tx, err := j.Database.GetDatabaseReference().Begin()
+	if err != nil {
+		return step, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(fmt.Sprintf("SELECT sql FROM temp.%s WHERE seq BETWEEN ? AND ? ORDER BY seq DESC",
+		journalTableName), step.Begin, step.End)
+	if err != nil {
+		return step, err
+	}
+	for rows.Next() {
+		var statement string
+		rows.Scan(&statement)
+		statements = append(statements, statement)
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("DELETE FROM temp.%s WHERE seq BETWEEN ? AND ?", journalTableName), step.Begin, step.End)
+	if err != nil {
+		return step, err
+	}
+
+	begin, err := j.getLastSequence(tx)
+	if err != nil {
+		return step, err
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement); err != nil {
+			return step, err
+		}
+	}
+	end, err := j.getLastSequence(tx)
+	if err != nil {
+		return step, err
+	}
+
+	return JournalStep{
+		Begin: begin + 1,
+		End:   end,
+	}, tx.Commit()
+}
+
+// GetChangedTables lists the tables that steps still in the undo or redo history changed
+func (j *Journal) GetChangedTables() (map[string]bool, error) {
+	tables := map[string]bool{}
+	rows, err := j.Database.GetDatabaseReference().Query(fmt.Sprintf("SELECT DISTINCT tbl FROM temp.%s", journalTableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		rows.Scan(&table)
+		tables[table] = true
+	}
+
+	return tables, rows.Err()
+}
+
+// JournalCell is a cell that an edit still in the undo history changed. Its row is found by the quoted values of
+// the table's key (see QuoteKey), and an empty column is a whole row that was inserted. Entries of the journal keep
+// the key of the row they changed and, for updates, the columns that changed, so none of this has to be diffed
+type JournalCell struct {
+	Table  string
+	Key    string
+	Column string
+}
+
+// GetChangedCells lists the cells changed by steps in the undo history, leaving out log entries up to since. It only
+// reads the journal, however big the tables are. Deleted rows and undone steps have nothing to show
+func (j *Journal) GetChangedCells(since int64) ([]JournalCell, error) {
+	rows, err := j.Database.GetDatabaseReference().Query(fmt.Sprintf(
+		"SELECT seq, tbl, key, cols FROM temp.%s WHERE seq > ? AND key IS NOT NULL ORDER BY seq", journalTableName), since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cells []JournalCell
+	for rows.Next() {
+		var (
+			seq        int64
+			table, key string
+			cols       sql.NullString
+		)
+		if err = rows.Scan(&seq, &table, &key, &cols); err != nil {
+			return nil, err
+		}
+		// steps only ever go on the undo stack with entries newer than any other, so it's ordered
+		i := sort.Search(len(j.UndoStack), func(i int) bool { return j.UndoStack[i].End >= seq })
+		if i == len(j.UndoStack) || j.UndoStack[i].Begin > seq {
+			continue // part of a step that was undone
+		}
+		if !cols.Valid {
+			cells = append(cells, JournalCell{Table: table, Key: key})
+			continue
+		}
+		for _, column := range strings.Split(cols.String, "\x00") {
+			if column == "" {
+				continue
+			}
+			cells = append(cells, JournalCell{Table: table, Key: key, Column: column})
+		}
+	}
+
+	return cells, rows.Err()
+}
+
+// GetLastSequence is the newest entry of the journal, or 0 if it's empty
+func (j *Journal) GetLastSequence() (int64, error) {
+	return j.getLastSequence(j.Database.GetDatabaseReference())
+}
+
+// QuoteKey writes the key values of a row the way the journal triggers record them with quote(), so cells read
+// from a table can be matched with GetChangedCells. Other than integers, text and blobs nothing can be matched
+func QuoteKey(values []interface{}) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		switch conv := v.(type) {
+		case nil:
+			quoted[i] = "NULL"
+		case int64:
+			quoted[i] = fmt.Sprintf("%d", conv)
+		case string:
+			quoted[i] = literal(conv)
+		case []byte:
+			quoted[i] = fmt.Sprintf("X'%X'", conv)
+		default:
+			quoted[i] = literal(fmt.Sprintf("%v", conv))
+		}
+	}
+
+	return strings.Join(quoted, ",")
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (j *Journal) getLastSequence(q queryRower) (int64, error) {
+	var seq sql.NullInt64
+	err := q.QueryRow(fmt.Sprintf("SELECT max(seq) FROM temp.%s", journalTableName)).Scan(&seq)
+	return seq.Int64, err
+}
+
+// getTriggers builds the statements that (re)create the insert, update and delete triggers for a table. Rows are
+// found again by rowid, or by primary key for WITHOUT ROWID tables
+func (j *Journal) getTriggers(table string) ([]string, error) {
+	var (
+		columns []string
+		keys    []string
+		rowKey  []string           // what the row of a cell is found by, the key GetTableKey gives the viewer
+		keyByPK = map[int]string{} // primary key columns by their position in the key
+	)
+
+	db := j.Database
+	rows, err := db.GetDatabaseReference().Query("SELECT name, pk FROM pragma_table_info(?) ORDER BY cid", table)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			name string
+			pk   int
+		)
+		rows.Scan(&name, &pk)
+		columns = append(columns, name)
+		if pk > 0 {
+			keys = append(keys, name)
+			keyByPK[pk] = name
+		}
+	}
+	rows.Close()
+
+	for pk := 1; pk <= len(keys); pk++ {
+		rowKey = append(rowKey, keyByPK[pk])
+	}
+	rowID := !j.isWithoutRowID(table)
+	if rowID {
+		keys = []string{"rowid"}
+		if len(rowKey) == 0 {
+			rowKey = keys
+		}
+	}
+
+	quotedTable := literal(db.QuoteIdentifier(table))
+	// old.x / new.x turned into a literal inside the generated statement
+	value := func(row, column string) string {
+		if column == "rowid" {
+			return fmt.Sprintf("quote(%s.rowid)", row)
+		}
+		return fmt.Sprintf("quote(%s.%s)", row, db.QuoteIdentifier(column))
+	}
+	name := func(column string) string {
+		if column == "rowid" {
+			return column
+		}
+		return db.QuoteIdentifier(column)
+	}
+	where := func(row string) string {
+		var conditions []string
+		for _, k := range keys {
+			conditions = append(conditions, fmt.Sprintf("%s||' IS '||%s", literal(name(k)), value(row, k)))
+		}
+		return strings.Join(conditions, "||' AND '||")
+	}
+
+	var (
+		assignments  []string
+		insertNames  []string
+		insertValues []string
+	)
+	allColumns := columns
+	if rowID {
+		allColumns = append([]string{"rowid"}, columns...)
+	}
+	for _, c := range allColumns {
+		assignments = append(assignments, fmt.Sprintf("%s||%s", literal(name(c)+"="), value("old", c)))
+		insertNames = append(insertNames, name(c))
+		insertValues = append(insertValues, value("old", c))
+	}
+
+	// the key of the changed row, and the columns an update changed each followed by char(0)
+	rowKeyValues := make([]string, len(rowKey))
+	for i, k := range rowKey {
+		rowKeyValues[i] = value("new", k)
+	}
+	var changed []string
+	for _, c := range columns {
+		changed = append(changed, fmt.Sprintf("CASE WHEN old.%[1]s IS NOT new.%[1]s THEN %[2]s||char(0) ELSE '' END",
+			db.QuoteIdentifier(c), literal(c)))
+	}
+
+	h := fnv.New32a() // trigger names just need to be unique per table
+	h.Write([]byte(table))
+	trigger := fmt.Sprintf("%s_%d", journalTableName, h.Sum32())
+	log := func(key, cols, statement string) string {
+		return fmt.Sprintf("INSERT INTO %s(tbl, key, cols, sql) VALUES(%s, %s, %s, %s);",
+			journalTableName, literal(table), key, cols, statement)
+	}
+	target := fmt.Sprintf("main.%s", db.QuoteIdentifier(table))
+	key := strings.Join(rowKeyValues, "||','||")
+	if key == "" { // nothing to find the row by, so there's nothing to highlight
+		key = "NULL"
+	}
+
+	return []string{
+		fmt.Sprintf("DROP TRIGGER IF EXISTS temp.%s_i", trigger),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS temp.%s_u", trigger),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS temp.%s_d", trigger),
+		fmt.Sprintf("CREATE TEMP TRIGGER %s_i AFTER INSERT ON %s BEGIN %s END", trigger, target,
+			log(key, "NULL", fmt.Sprintf("'DELETE FROM '||%s||' WHERE '||%s", quotedTable, where("new")))),
+		fmt.Sprintf("CREATE TEMP TRIGGER %s_u AFTER UPDATE ON %s BEGIN %s END", trigger, target,
+			log(key, strings.Join(changed, "||"), fmt.Sprintf("'UPDATE '||%s||' SET '||%s||' WHERE '||%s",
+				quotedTable, strings.Join(assignments, "||','||"), where("new")))),
+		fmt.Sprintf("CREATE TEMP TRIGGER %s_d BEFORE DELETE ON %s BEGIN %s END", trigger, target,
+			log("NULL", "NULL", fmt.Sprintf("'INSERT INTO '||%s||'(%s) VALUES('||%s||')'", quotedTable,
+				strings.ReplaceAll(strings.Join(insertNames, ","), "'", "''"), strings.Join(insertValues, "||','||")))),
+	}, nil
+}
+
+func (j *Journal) isWithoutRowID(table string) bool {
+	var definition sql.NullString
+	j.Database.GetDatabaseReference().QueryRow("SELECT sql FROM sqlite_master WHERE name = ?", table).Scan(&definition)
+	return strings.Contains(strings.ToUpper(definition.String), "WITHOUT ROWID")
+}
+
+// literal quotes s as a SQL string literal
+func literal(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
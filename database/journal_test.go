@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// openJournal makes a SQLite database with statements run and journals it
+func openJournal(t *testing.T, statements ...string) *Journal {
+	t.Helper()
+
+	conn, err := sql.Open(DriverSQLite, filepath.Join(t.TempDir(), "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxOpenConns(1) // the journal lives on the connection
+	t.Cleanup(func() { conn.Close() })
+	for _, statement := range statements {
+		if _, err = conn.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	j, err := NewJournal(&SQLite{Database: conn})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return j
+}
+
+// changedCells is every cell GetChangedCells gives, as table key column
+func changedCells(t *testing.T, j *Journal, since int64) []string {
+	t.Helper()
+
+	cells, err := j.GetChangedCells(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read []string
+	for _, c := range cells {
+		read = append(read, strings.TrimSpace(strings.Join([]string{c.Table, c.Key, c.Column}, " ")))
+	}
+	sort.Strings(read)
+
+	return read
+}
+
+func TestJournalChangedCells(t *testing.T) {
+	j := openJournal(t,
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, total INT, note TEXT)",
+		"CREATE TABLE notes (body TEXT)",
+		"CREATE TABLE lines (product TEXT, n INT, qty INT, PRIMARY KEY (n, product)) WITHOUT ROWID",
+		"INSERT INTO orders VALUES (1, 5, 'a'), (2, 6, 'b')",
+		"INSERT INTO notes VALUES ('x')",
+		"INSERT INTO lines VALUES ('it''s', 1, 2)",
+	)
+	db := j.Database.GetDatabaseReference()
+	exec := func(statement string) {
+		t.Helper()
+		if err := j.Record(func() error {
+			_, err := db.Exec(statement)
+			return err
+		}); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	exec("UPDATE orders SET total = 7, note = 'a' WHERE id = 1") // note doesn't change
+	exec("INSERT INTO orders VALUES (3, 1, NULL)")
+	exec("DELETE FROM orders WHERE id = 2")
+	exec("UPDATE notes SET body = 'y'")
+	exec("UPDATE lines SET qty = 3")
+
+	want := []string{"lines 1,'it''s' qty", "notes 1 body", "orders 1 total", "orders 3"}
+	if cells := changedCells(t, j, 0); strings.Join(cells, ", ") != strings.Join(want, ", ") {
+		t.Errorf("cells = %q, want %q", cells, want)
+	}
+	if key := QuoteKey([]interface{}{int64(1), "it's"}); key != "1,'it''s'" {
+		t.Errorf("QuoteKey = %s", key)
+	}
+
+	// undone steps have nothing to show until they're redone
+	for i := 0; i < 3; i++ {
+		if err := j.Undo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want = []string{"orders 1 total", "orders 3"}
+	if cells := changedCells(t, j, 0); strings.Join(cells, ", ") != strings.Join(want, ", ") {
+		t.Errorf("after undo, cells = %q, want %q", cells, want)
+	}
+	if err := j.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"orders 1 total", "orders 3"} // the delete shows nothing
+	if cells := changedCells(t, j, 0); strings.Join(cells, ", ") != strings.Join(want, ", ") {
+		t.Errorf("after redo, cells = %q, want %q", cells, want)
+	}
+
+	// only entries after since count
+	since, err := j.GetLastSequence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec("UPDATE orders SET note = 'c' WHERE id = 3")
+	want = []string{"orders 3 note"}
+	if cells := changedCells(t, j, since); strings.Join(cells, ", ") != strings.Join(want, ", ") {
+		t.Errorf("since %d, cells = %q, want %q", since, cells, want)
+	}
+}
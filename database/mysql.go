@@ -0,0 +1,307 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// MySQL is a Database backed by a MySQL (or MySQL wire-compatible) server. FileName holds the DSN,
+// i.e. user:password@tcp(127.0.0.1:3306)/schema
+type MySQL struct {
+	FileName string
+	Database *sql.DB
+}
+
+func (db *MySQL) Update(q *Update) error {
+	return executeUpdate(db, q)
+}
+
+func (db *MySQL) GetFileName() string {
+	return db.FileName
+}
+
+func (db *MySQL) GetDatabaseReference() *sql.DB {
+	return db.Database
+}
+
+func (db *MySQL) CloseDatabaseReference() {
+	db.GetDatabaseReference().Close()
+	DBMutex.Lock()
+	delete(Databases, db.FileName)
+	DBMutex.Unlock()
+	db.Database = nil
+}
+
+func (db *MySQL) SetDatabaseReference(dbPath string) error {
+	database, err := GetDatabaseForFile(dbPath)
+	if err != nil {
+		return err
+	}
+	db.FileName = dbPath
+	db.Database = database
+
+	return nil
+}
+
+func (db MySQL) GetPlaceholderForDatabaseType(position int) string {
+	return "?"
+}
+
+func (db MySQL) GetTableNamesQuery() string {
+	val := "SELECT table_name, table_type = 'VIEW' FROM "
+	val += "information_schema.tables"
+	val += " WHERE table_schema = DATABASE() AND table_type IN ('BASE TABLE', 'VIEW')"
+	val += " ORDER BY table_name"
+
+	return val
+}
+
+func (db MySQL) QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// GetTableKey uses the primary key of the table, the columns GetColumns marks as such. MySQL has no row id to
+// fall back on
+func (db *MySQL) GetTableKey(tableName string) TableKey {
+	var (
+		key       TableKey
+		tableType string
+	)
+
+	db.GetDatabaseReference().QueryRow("SELECT table_type FROM information_schema.tables"+
+		" WHERE table_schema = DATABASE() AND table_name = ?", tableName).Scan(&tableType)
+	if tableType == "VIEW" {
+		key.View = true
+		key.Warning = fmt.Sprintf("%s is a view and can't be edited.", tableName)
+		return key
+	}
+
+	rows, err := db.GetDatabaseReference().Query("SELECT column_name FROM information_schema.columns"+
+		" WHERE table_schema = DATABASE() AND table_name = ? AND column_key = 'PRI'"+
+		" ORDER BY ordinal_position", tableName)
+	if err != nil {
+		key.Warning = fmt.Sprintf("Could not read primary key of %s: %v", tableName, err)
+		return key
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var column string
+		rows.Scan(&column)
+		key.Columns = append(key.Columns, column)
+	}
+
+	if len(key.Columns) == 0 {
+		key.Warning = fmt.Sprintf("%s has no primary key, edits update the first row with matching values.", tableName)
+	}
+
+	return key
+}
+
+func (db *MySQL) GetColumns(tableName string) ([]Column, error) {
+	var columns []Column
+
+	rows, err := db.GetDatabaseReference().Query("SELECT column_name, column_type, is_nullable = 'NO', column_default, column_key = 'PRI'"+
+		" FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?"+
+		" ORDER BY ordinal_position", tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c Column
+		if err = rows.Scan(&c.Name, &c.Type, &c.NotNull, &c.Default, &c.PrimaryKey); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+
+	return columns, rows.Err()
+}
+
+func (db *MySQL) GenerateQuery(u *Update) (string, []string) {
+	var (
+		query         string
+		querySkeleton string
+		valueOrder    []string
+	)
+
+	querySkeleton = fmt.Sprintf("UPDATE %s"+
+		" SET %s=%s", db.QuoteIdentifier(u.TableName), db.QuoteIdentifier(u.Column), db.GetPlaceholderForDatabaseType(1))
+	valueOrder = append(valueOrder, u.Column)
+
+	where, columns := db.GetRowCondition(u.TableName, u.GetValues(), 2)
+	valueOrder = append(valueOrder, columns...)
+
+	query = querySkeleton + " WHERE " + where + " LIMIT 1;"
+	return query, valueOrder
+}
+
+// GetRowCondition builds the where clause that singles out the row with the given values. Statements
+// using it should LIMIT 1, since keyless tables may have duplicate rows
+func (db *MySQL) GetRowCondition(tableName string, values map[string]interface{}, firstPosition int) (string, []string) {
+	key := db.GetTableKey(tableName)
+	columns := keyColumns(key, values)
+	return keyedWhereClause(db, key, columns, "<=>", firstPosition), columns
+}
+
+func (db *MySQL) Insert(q *Insert) error {
+	return executeInsert(db, q)
+}
+
+func (db *MySQL) Delete(q *Delete) error {
+	where, columns := db.GetRowCondition(q.TableName, q.GetValues(), 1)
+	return executeStatement(db, fmt.Sprintf("DELETE FROM %s WHERE %s LIMIT 1;", db.QuoteIdentifier(q.TableName), where),
+		getValuesInOrder(columns, q.GetValues()))
+}
+
+func (db *MySQL) GetForeignKeys(tableName string) ([]ForeignKey, error) {
+	var keys []ForeignKey
+
+	rows, err := db.GetDatabaseReference().Query("SELECT k.constraint_name, k.column_name, k.referenced_table_name,"+
+		" k.referenced_column_name, r.update_rule, r.delete_rule"+
+		" FROM information_schema.key_column_usage k JOIN information_schema.referential_constraints r"+
+		" ON r.constraint_schema = k.constraint_schema AND r.constraint_name = k.constraint_name AND r.table_name = k.table_name"+
+		" WHERE k.table_schema = DATABASE() AND k.table_name = ? AND k.referenced_table_name IS NOT NULL"+
+		" ORDER BY k.constraint_name, k.ordinal_position", tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	last := ""
+	for rows.Next() {
+		var name, column, table, reference, onUpdate, onDelete string
+		if err = rows.Scan(&name, &column, &table, &reference, &onUpdate, &onDelete); err != nil {
+			return nil, err
+		}
+		if name != last { // one row per column of the key
+			keys = append(keys, ForeignKey{
+				Table:    table,
+				OnUpdate: onUpdate,
+				OnDelete: onDelete,
+			})
+			last = name
+		}
+		k := &keys[len(keys)-1]
+		k.Columns = append(k.Columns, column)
+		k.References = append(k.References, reference)
+	}
+
+	return keys, rows.Err()
+}
+
+func (db *MySQL) GetIndexes(tableName string) ([]Index, error) {
+	var indexes []Index
+
+	rows, err := db.GetDatabaseReference().Query("SELECT index_name, non_unique = 0, column_name"+
+		" FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ?"+
+		" ORDER BY index_name, seq_in_index", tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name   string
+			unique bool
+			column sql.NullString // null for functional key parts
+		)
+		if err = rows.Scan(&name, &unique, &column); err != nil {
+			return nil, err
+		}
+		if !column.Valid {
+			column.String = "<expression>"
+		}
+		if len(indexes) == 0 || indexes[len(indexes)-1].Name != name {
+			indexes = append(indexes, Index{
+				Name:   name,
+				Unique: unique,
+			})
+		}
+		i := &indexes[len(indexes)-1]
+		i.Columns = append(i.Columns, column.String)
+	}
+
+	return indexes, rows.Err()
+}
+
+func (db *MySQL) GetSchemaObjects() ([]SchemaObject, error) {
+	var (
+		objects  []SchemaObject
+		triggers []SchemaObject
+	)
+
+	rows, err := db.GetDatabaseReference().Query("SELECT table_name, table_type = 'VIEW' FROM information_schema.tables" +
+		" WHERE table_schema = DATABASE() AND table_type IN ('BASE TABLE', 'VIEW') ORDER BY table_type, table_name")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			o    SchemaObject
+			view bool
+		)
+		if err = rows.Scan(&o.Name, &view); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		o.Type = "table"
+		if view {
+			o.Type = "view"
+		}
+		o.Table = o.Name
+		objects = append(objects, o)
+	}
+	rows.Close()
+
+	var indexes []SchemaObject
+	for i, o := range objects {
+		if o.Type == "view" {
+			var definition string
+			db.GetDatabaseReference().QueryRow("SELECT view_definition FROM information_schema.views"+
+				" WHERE table_schema = DATABASE() AND table_name = ?", o.Name).Scan(&definition)
+			objects[i].SQL = fmt.Sprintf("CREATE VIEW %s AS %s;", db.QuoteIdentifier(o.Name), definition)
+			continue
+		}
+
+		var name string
+		db.GetDatabaseReference().QueryRow(fmt.Sprintf("SHOW CREATE TABLE %s", db.QuoteIdentifier(o.Name))).Scan(&name, &objects[i].SQL)
+		tableIndexes, err := db.GetIndexes(o.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range tableIndexes {
+			if index.Name == "PRIMARY" { // part of the table definition
+				continue
+			}
+			indexes = append(indexes, SchemaObject{
+				Type:  "index",
+				Name:  index.Name,
+				Table: o.Name,
+				SQL:   indexDefinition(db, o.Name, index),
+			})
+		}
+	}
+
+	rows, err = db.GetDatabaseReference().Query("SELECT trigger_name, event_object_table, action_timing, event_manipulation, action_statement" +
+		" FROM information_schema.triggers WHERE trigger_schema = DATABASE() ORDER BY trigger_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			o                     SchemaObject
+			timing, event, action string
+		)
+		if err = rows.Scan(&o.Name, &o.Table, &timing, &event, &action); err != nil {
+			return nil, err
+		}
+		o.Type = "trigger"
+		o.SQL = fmt.Sprintf("CREATE TRIGGER %s %s %s ON %s FOR EACH ROW %s;", db.QuoteIdentifier(o.Name), timing, event,
+			db.QuoteIdentifier(o.Table), action)
+		triggers = append(triggers, o)
+	}
+
+	return append(append(objects, indexes...), triggers...), rows.Err()
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/server"
+	gms "github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/go-mysql-server/sql/information_schema"
+)
+
+// startMySQL serves an empty schema over the MySQL protocol with go-mysql-server, and connects to it the way
+// termdbms does
+func startMySQL(t *testing.T, statements ...string) *MySQL {
+	t.Helper()
+
+	provider := gms.NewDatabaseProvider(memory.NewDatabase("shop"), information_schema.NewInformationSchemaDatabase())
+	s, err := server.NewDefaultServer(server.Config{Protocol: "tcp", Address: "127.0.0.1:0"}, sqle.NewDefault(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+
+	driver := DriverString
+	DriverString = DriverMySQL
+	dsn := fmt.Sprintf("root@tcp(%s)/shop", s.Listener.Addr())
+	conn, err := GetDatabaseForFile(dsn)
+	DriverString = driver
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	db := &MySQL{FileName: dsn, Database: conn}
+	t.Cleanup(func() {
+		db.CloseDatabaseReference()
+		s.Close()
+	})
+
+	for _, statement := range statements {
+		if _, err = conn.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	return db
+}
+
+// readRows is every row of a table as text, in key order
+func readRows(t *testing.T, db *MySQL, table, order string) []string {
+	t.Helper()
+
+	rows, err := db.GetDatabaseReference().Query(fmt.Sprintf("SELECT * FROM %s ORDER BY %s",
+		db.QuoteIdentifier(table), order))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var read []string
+	columns, _ := rows.Columns()
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			t.Fatal(err)
+		}
+		fields := make([]string, len(values))
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			fields[i] = fmt.Sprint(v)
+		}
+		read = append(read, strings.Join(fields, "|"))
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return read
+}
+
+func TestMySQLTableNames(t *testing.T) {
+	db := startMySQL(t,
+		"CREATE TABLE orders (id INT PRIMARY KEY, total INT)",
+		"CREATE TABLE `odd``name` (id INT PRIMARY KEY)",
+		"CREATE VIEW big_orders AS SELECT * FROM orders WHERE total > 100",
+	)
+
+	rows, err := db.GetDatabaseReference().Query(db.GetTableNamesQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var (
+			name string
+			view bool
+		)
+		if err = rows.Scan(&name, &view); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, fmt.Sprintf("%s %v", name, view))
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"big_orders true", "odd`name false", "orders false"}
+	if strings.Join(tables, ", ") != strings.Join(want, ", ") {
+		t.Errorf("tables = %q, want %q", tables, want)
+	}
+}
+
+func TestMySQLGenerateQuery(t *testing.T) {
+	db := startMySQL(t, "CREATE TABLE `odd``name` (`key col` INT PRIMARY KEY, `we``ird` TEXT)")
+
+	u := &Update{TableName: "odd`name", Column: "we`ird", Update: "x"}
+	u.SetValues(map[string]interface{}{"key col": 1, "we`ird": "y"})
+	query, order := db.GenerateQuery(u)
+
+	want := "UPDATE `odd``name` SET `we``ird`=? WHERE `key col` <=> ? LIMIT 1;"
+	if query != want {
+		t.Errorf("query = %q, want %q", query, want)
+	}
+	if strings.Join(order, ",") != "we`ird,key col" {
+		t.Errorf("value order = %q", order)
+	}
+}
+
+func TestMySQLKeyedEdits(t *testing.T) {
+	db := startMySQL(t,
+		"CREATE TABLE `odd``name` (id INT PRIMARY KEY, `we``ird` TEXT)",
+		"INSERT INTO `odd``name` VALUES (1, 'a'), (2, 'a')",
+	)
+	table := "odd`name"
+
+	u := &Update{TableName: table, Column: "we`ird", Update: "b"}
+	u.SetValues(map[string]interface{}{"id": 2, "we`ird": "changed since"}) // only the key picks the row
+	if err := db.Update(u); err != nil {
+		t.Fatal(err)
+	}
+
+	i := &Insert{TableName: table}
+	i.SetValues(map[string]interface{}{"id": 3, "we`ird": nil})
+	if err := db.Insert(i); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Delete{TableName: table}
+	d.SetValues(map[string]interface{}{"id": 1, "we`ird": "changed since"})
+	if err := db.Delete(d); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"2|b", "3|<nil>"}
+	if rows := readRows(t, db, table, "id"); strings.Join(rows, ", ") != strings.Join(want, ", ") {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
+
+func TestMySQLKeylessEdits(t *testing.T) {
+	db := startMySQL(t,
+		"CREATE TABLE notes (body TEXT, n INT)",
+		"INSERT INTO notes VALUES ('a', 1), ('a', 1), (NULL, 2)",
+	)
+
+	u := &Update{TableName: "notes", Column: "n", Update: 3}
+	u.SetValues(map[string]interface{}{"body": nil, "n": 2}) // NULL still matches
+	if err := db.Update(u); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Delete{TableName: "notes"}
+	d.SetValues(map[string]interface{}{"body": "a", "n": 1}) // only one of the duplicates goes
+	if err := db.Delete(d); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a|1", "<nil>|3"}
+	if rows := readRows(t, db, "notes", "n"); strings.Join(rows, ", ") != strings.Join(want, ", ") {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
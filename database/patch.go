@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// rebuiltTablePrefix names the old copy of an altered table while its rows move to the new one
+const rebuiltTablePrefix = "termdbms_old_"
+
+// WriteSQLitePatch writes a script that turns the base database of changes into the database db is connected to.
+// Altered tables are rebuilt, keeping the columns both versions have. Triggers of tables whose rows change are
+// dropped while the rows are written and created again after, so they don't fire twice
+func WriteSQLitePatch(w io.Writer, db *sql.DB, changes []Change) error {
+	var (
+		quote     = SQLite{}.QuoteIdentifier
+		sqlite    = &SQLite{}
+		b         strings.Builder
+		rebuilt   = map[string]bool{} // altered tables, their indexes and triggers go with the old copy
+		rowTables = map[string]bool{} // tables with changed rows, their triggers are held back
+		dropped   = map[string]bool{}
+		created   = map[string]bool{}
+	)
+	for _, c := range changes {
+		if c.Kind != ChangeSchema {
+			rowTables[c.Table] = true
+		} else if c.ObjectType == "table" && c.GetSchemaChangeVerb() == "alter" {
+			rebuilt[c.Object] = true
+		}
+	}
+	objects, err := getPatchObjects(db)
+	if err != nil {
+		return err
+	}
+
+	b.WriteString("-- generated by termdbms\nPRAGMA foreign_keys = OFF;\nPRAGMA legacy_alter_table = ON;\nBEGIN TRANSACTION;\n")
+
+	// indexes, views and triggers that change or go away
+	for _, c := range changes {
+		if c.Kind == ChangeSchema && c.ObjectType != "table" && c.OldStatement != "" {
+			fmt.Fprintf(&b, "DROP %s IF EXISTS %s;\n", strings.ToUpper(c.ObjectType), quote(c.Object))
+			dropped[c.Object] = true
+		}
+	}
+	for _, o := range objects {
+		if o.Type == "trigger" && (rowTables[o.Table] || rebuilt[o.Table]) && !dropped[o.Name] {
+			fmt.Fprintf(&b, "DROP TRIGGER IF EXISTS %s;\n", quote(o.Name))
+			dropped[o.Name] = true
+		}
+	}
+
+	table := ""
+	for i, c := range changes {
+		if c.Table != table {
+			table = c.Table
+			fmt.Fprintf(&b, "\n-- %s\n", table)
+		}
+		target := quote(c.Table)
+		switch c.Kind {
+		case ChangeSchema:
+			if c.ObjectType != "table" {
+				continue
+			}
+			switch c.GetSchemaChangeVerb() {
+			case "create":
+				b.WriteString(c.Statement + ";\n")
+			case "drop":
+				fmt.Fprintf(&b, "DROP TABLE %s;\n", quote(c.Object))
+			case "alter":
+				old := quote(rebuiltTablePrefix + c.Object)
+				columns := make([]string, len(c.Columns))
+				for i, column := range c.Columns {
+					columns[i] = quote(column)
+				}
+				fmt.Fprintf(&b, "ALTER TABLE %s RENAME TO %s;\n%s;\n", quote(c.Object), old, c.Statement)
+				if len(columns) > 0 && !isWithoutRowid(c.OldStatement) && !isWithoutRowid(c.Statement) {
+					columns = append([]string{"rowid"}, columns...) // keyless rows are matched by rowid
+				}
+				if len(columns) > 0 {
+					fmt.Fprintf(&b, "INSERT INTO %s (%s) SELECT %s FROM %s;\n", quote(c.Object),
+						strings.Join(columns, ", "), strings.Join(columns, ", "), old)
+				}
+				fmt.Fprintf(&b, "DROP TABLE %s;\n", old)
+			}
+		case ChangeDelete:
+			fmt.Fprintf(&b, "DELETE FROM %s WHERE %s;\n", target, patchKeyCondition(sqlite, c))
+		case ChangeInsert:
+			values := make([]string, len(c.Row))
+			for i, v := range c.Row {
+				values[i] = ValueLiteral(sqlite, v)
+			}
+			if len(c.KeyColumns) == 1 && c.KeyColumns[0] == "rowid" {
+				values = append([]string{ValueLiteral(sqlite, c.Key[0])}, values...)
+			}
+			fmt.Fprintf(&b, "INSERT INTO %s (%s) VALUES (%s);\n", target, columnList(c), strings.Join(values, ", "))
+		case ChangeUpdate:
+			if i > 0 && changes[i-1].Kind == ChangeUpdate && sameRow(changes[i-1], c) {
+				continue // written with the first cell of the row
+			}
+			var assignments []string
+			for _, u := range changes[i:] {
+				if u.Kind != ChangeUpdate || !sameRow(u, c) {
+					break
+				}
+				assignments = append(assignments, fmt.Sprintf("%s = %s", quote(u.Column), ValueLiteral(sqlite, u.New)))
+			}
+			fmt.Fprintf(&b, "UPDATE %s SET %s WHERE %s;\n", target, strings.Join(assignments, ", "),
+				patchKeyCondition(sqlite, c))
+		}
+	}
+
+	// indexes, views and triggers that are new or changed, or were held back or went with a rebuilt table
+	b.WriteString("\n")
+	for _, c := range changes {
+		if c.Kind == ChangeSchema && c.ObjectType != "table" && c.Statement != "" {
+			b.WriteString(c.Statement + ";\n")
+			created[c.Object] = true
+		}
+	}
+	for _, o := range objects {
+		again := (o.Type == "index" && rebuilt[o.Table]) || (o.Type == "trigger" && dropped[o.Name])
+		if again && !created[o.Name] {
+			b.WriteString(o.SQL + ";\n")
+		}
+	}
+	b.WriteString("COMMIT;\n")
+
+	_, err = io.WriteString(w, b.String())
+
+	return err
+}
+
+// patchObject is an index or trigger of the new database, which a patch may have to create again
+type patchObject struct {
+	Type  string
+	Name  string
+	Table string
+	SQL   string
+}
+
+func getPatchObjects(db *sql.DB) ([]patchObject, error) {
+	var objects []patchObject
+	rows, err := db.Query("SELECT type, name, tbl_name, sql FROM main.sqlite_master " +
+		"WHERE type IN ('index', 'trigger') AND sql IS NOT NULL ORDER BY type, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o patchObject
+		rows.Scan(&o.Type, &o.Name, &o.Table, &o.SQL)
+		objects = append(objects, o)
+	}
+
+	return objects, rows.Err()
+}
+
+// patchKeyCondition matches the row of a change by its key
+func patchKeyCondition(db Database, c Change) string {
+	var conditions []string
+	for i, k := range c.KeyColumns {
+		if k != "rowid" {
+			k = db.QuoteIdentifier(k)
+		}
+		conditions = append(conditions, fmt.Sprintf("%s = %s", k, ValueLiteral(db, c.Key[i])))
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+func isWithoutRowid(statement string) bool {
+	return strings.Contains(strings.ToUpper(strings.Join(strings.Fields(statement), " ")), "WITHOUT ROWID")
+}
+
+func sameRow(a, b Change) bool {
+	return a.Table == b.Table && fmt.Sprintf("%v", a.Key) == fmt.Sprintf("%v", b.Key)
+}
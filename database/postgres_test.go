@@ -0,0 +1,366 @@
+package database
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	_ "github.com/lib/pq"
+)
+
+// pgStatement is a statement the double was sent, with its arguments as text
+type pgStatement struct {
+	Query string
+	Args  []string
+}
+
+// pgAnswer is what the double answers a statement with. Statements without columns don't return rows
+type pgAnswer struct {
+	Columns []string
+	Rows    [][]string
+	Err     string
+}
+
+// pgDouble speaks just enough of the PostgreSQL wire protocol for lib/pq: startup without a password, simple
+// queries, and statements prepared with parameters. It answers everything with answer and records what it's sent
+type pgDouble struct {
+	answer func(query string, args []string) pgAnswer
+
+	mu         sync.Mutex
+	statements []pgStatement
+}
+
+// startPostgres serves a double and connects to it the way termdbms does
+func startPostgres(t *testing.T, answer func(query string, args []string) pgAnswer) (*Postgres, *pgDouble) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	double := &pgDouble{answer: answer}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go double.serve(conn)
+		}
+	}()
+
+	driver := DriverString
+	DriverString = DriverPostgres
+	dsn := fmt.Sprintf("postgres://termdbms@%s/shop?sslmode=disable", l.Addr())
+	conn, err := GetDatabaseForFile(dsn)
+	DriverString = driver
+	if err != nil {
+		l.Close()
+		t.Fatal(err)
+	}
+	db := &Postgres{FileName: dsn, Database: conn}
+	t.Cleanup(func() {
+		db.CloseDatabaseReference()
+		l.Close()
+	})
+
+	return db, double
+}
+
+// Statements is everything the double was sent but transaction control
+func (d *pgDouble) Statements() []pgStatement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]pgStatement(nil), d.statements...)
+}
+
+func (d *pgDouble) serve(conn net.Conn) {
+	defer conn.Close()
+	b := pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn)
+	if _, err := b.ReceiveStartupMessage(); err != nil {
+		return
+	}
+	b.Send(&pgproto3.AuthenticationOk{})
+	b.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+
+	var (
+		status   byte = 'I'
+		prepared      = make(map[string]string) // statement name to query
+		portal   pgStatement
+		failed   bool // skipping to the next sync after an error
+	)
+	for {
+		msg, err := b.Receive()
+		if err != nil {
+			return
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.Query:
+			if keyword := strings.ToUpper(strings.Fields(msg.String)[0]); keyword == "BEGIN" {
+				status = 'T'
+				b.Send(&pgproto3.CommandComplete{CommandTag: []byte(keyword)})
+			} else if keyword == "COMMIT" || keyword == "ROLLBACK" {
+				status = 'I'
+				b.Send(&pgproto3.CommandComplete{CommandTag: []byte(keyword)})
+			} else {
+				a := d.record(msg.String, nil)
+				if a.Err == "" && len(a.Columns) > 0 {
+					b.Send(rowDescription(a.Columns))
+				}
+				d.sendResult(b, msg.String, a)
+			}
+			b.Send(&pgproto3.ReadyForQuery{TxStatus: status})
+		case *pgproto3.Parse:
+			if !failed {
+				prepared[msg.Name] = msg.Query
+				b.Send(&pgproto3.ParseComplete{})
+			}
+		case *pgproto3.Describe:
+			if failed {
+				break
+			} else if msg.ObjectType == 'S' {
+				query := prepared[msg.Name]
+				n := countParameters(query)
+				b.Send(&pgproto3.ParameterDescription{ParameterOIDs: make([]uint32, n)})
+				if a := d.answer(query, make([]string, n)); len(a.Columns) > 0 {
+					b.Send(rowDescription(a.Columns))
+				} else {
+					b.Send(&pgproto3.NoData{})
+				}
+			}
+		case *pgproto3.Bind:
+			if !failed {
+				portal = pgStatement{Query: prepared[msg.PreparedStatement]}
+				for _, p := range msg.Parameters {
+					portal.Args = append(portal.Args, string(p))
+				}
+				b.Send(&pgproto3.BindComplete{})
+			}
+		case *pgproto3.Execute:
+			if !failed {
+				failed = !d.sendResult(b, portal.Query, d.record(portal.Query, portal.Args))
+			}
+		case *pgproto3.Close:
+			delete(prepared, msg.Name)
+			b.Send(&pgproto3.CloseComplete{})
+		case *pgproto3.Sync:
+			failed = false
+			b.Send(&pgproto3.ReadyForQuery{TxStatus: status})
+		case *pgproto3.Terminate:
+			return
+		}
+	}
+}
+
+func (d *pgDouble) record(query string, args []string) pgAnswer {
+	d.mu.Lock()
+	d.statements = append(d.statements, pgStatement{Query: query, Args: args})
+	d.mu.Unlock()
+
+	return d.answer(query, args)
+}
+
+// sendResult sends the rows of an answer and the tag that ends them, or its error
+func (d *pgDouble) sendResult(b *pgproto3.Backend, query string, a pgAnswer) bool {
+	if a.Err != "" {
+		b.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42000", Message: a.Err})
+		return false
+	}
+
+	tag := fmt.Sprintf("SELECT %d", len(a.Rows))
+	if len(a.Columns) == 0 {
+		tag = strings.ToUpper(strings.Fields(query)[0]) + " 1"
+		if strings.HasPrefix(tag, "INSERT") {
+			tag = "INSERT 0 1"
+		}
+	}
+	for _, row := range a.Rows {
+		values := make([][]byte, len(row))
+		for i, v := range row {
+			values[i] = []byte(v)
+		}
+		b.Send(&pgproto3.DataRow{Values: values})
+	}
+	b.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag)})
+
+	return true
+}
+
+// rowDescription describes text columns
+func rowDescription(columns []string) *pgproto3.RowDescription {
+	fields := make([]pgproto3.FieldDescription, len(columns))
+	for i, c := range columns {
+		fields[i] = pgproto3.FieldDescription{Name: []byte(c), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1}
+	}
+
+	return &pgproto3.RowDescription{Fields: fields}
+}
+
+// countParameters is the highest $n of a query
+func countParameters(query string) int {
+	n := 0
+	for i := strings.Index(query, "$"); i > -1; i = strings.Index(query, "$") {
+		query = query[i+1:]
+		end := 0
+		for end < len(query) && query[end] >= '0' && query[end] <= '9' {
+			end++
+		}
+		if p, err := strconv.Atoi(query[:end]); err == nil && p > n {
+			n = p
+		}
+	}
+
+	return n
+}
+
+// keyedTables answers the questions GetTableKey asks with tables that are keyed by id
+func keyedTables(query string, args []string) pgAnswer {
+	switch {
+	case strings.Contains(query, "SELECT table_type FROM information_schema.tables"):
+		return pgAnswer{Columns: []string{"table_type"}, Rows: [][]string{{"BASE TABLE"}}}
+	case strings.Contains(query, "i.indisprimary"):
+		return pgAnswer{Columns: []string{"attname"}, Rows: [][]string{{"id"}}}
+	}
+
+	return pgAnswer{}
+}
+
+func TestPostgresSplitTableName(t *testing.T) {
+	db := &Postgres{}
+	for _, test := range []struct {
+		name, schema, table, quoted string
+	}{
+		{"public.orders", "public", "orders", `"public"."orders"`},
+		{"public.orders.2021", "public", "orders.2021", `"public"."orders.2021"`},
+		{`"sales.eu".orders`, "sales.eu", "orders", `"sales.eu"."orders"`},
+		{`"a.""b""".c.d`, `a."b"`, "c.d", `"a.""b"""."c.d"`},
+		{`"odd`, "", `"odd`, `"""odd"`},
+	} {
+		schema, table := SplitTableName(db, test.name)
+		if schema != test.schema || table != test.table {
+			t.Errorf("SplitTableName(%q) = %q, %q, want %q, %q", test.name, schema, table, test.schema, test.table)
+		}
+		if quoted := QuoteTableName(db, test.name); quoted != test.quoted {
+			t.Errorf("QuoteTableName(%q) = %s, want %s", test.name, quoted, test.quoted)
+		}
+	}
+
+	if schema, table := SplitTableName(&MySQL{}, "shop.orders"); schema != "" || table != "shop.orders" {
+		t.Errorf("MySQL table names have no schema, got %q, %q", schema, table)
+	}
+}
+
+func TestPostgresPlaceholders(t *testing.T) {
+	db, double := startPostgres(t, keyedTables)
+	table := `"sales.eu".orders`
+
+	u := &Update{TableName: table, Column: "total", Update: "10"}
+	u.SetValues(map[string]interface{}{"id": "2", "total": "5"})
+	if err := db.Update(u); err != nil {
+		t.Fatal(err)
+	}
+	i := &Insert{TableName: table}
+	i.SetValues(map[string]interface{}{"id": "3", "total": "7"})
+	if err := db.Insert(i); err != nil {
+		t.Fatal(err)
+	}
+	d := &Delete{TableName: table}
+	d.SetValues(map[string]interface{}{"id": "1", "total": "5"})
+	if err := db.Delete(d); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, s := range double.Statements() {
+		if strings.HasPrefix(s.Query, "SELECT") {
+			continue
+		}
+		got = append(got, fmt.Sprintf("%s %q", s.Query, s.Args))
+	}
+	want := []string{
+		`UPDATE "sales.eu"."orders" SET "total"=$1 WHERE "id" IS NOT DISTINCT FROM $2; ["10" "2"]`,
+		`INSERT INTO "sales.eu"."orders" ("id", "total") VALUES ($1, $2); ["3" "7"]`,
+		`DELETE FROM "sales.eu"."orders" WHERE "id" IS NOT DISTINCT FROM $1; ["1"]`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("statements:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPostgresTablesPerSchema(t *testing.T) {
+	db, double := startPostgres(t, func(query string, args []string) pgAnswer {
+		switch {
+		case query == (Postgres{}).GetTableNamesQuery():
+			return pgAnswer{Columns: []string{"name", "view"}, Rows: [][]string{
+				{"public.orders", "f"},
+				{"public.orders.2021", "f"},
+				{`"sales.eu".orders`, "f"},
+				{`"sales.eu".totals`, "t"},
+			}}
+		case strings.Contains(query, "FROM information_schema.columns"):
+			return pgAnswer{Columns: []string{"column_name", "data_type", "not_null", "column_default"},
+				Rows: [][]string{{"id", "integer", "t", "0"}}}
+		}
+		return keyedTables(query, args)
+	})
+
+	objects, err := db.GetSchemaObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, o := range objects {
+		names = append(names, o.Type+" "+o.Name)
+	}
+	want := []string{"table public.orders", "table public.orders.2021", `table "sales.eu".orders`, `view "sales.eu".totals`}
+	if strings.Join(names, ", ") != strings.Join(want, ", ") {
+		t.Errorf("objects = %q, want %q", names, want)
+	}
+
+	// every table is looked up in its own schema, with the arguments numbered from $1
+	var lookups []string
+	for _, s := range double.Statements() {
+		if strings.Contains(s.Query, "$1") && countParameters(s.Query) != len(s.Args) {
+			t.Errorf("%d arguments for %s", len(s.Args), s.Query)
+		}
+		if strings.Contains(s.Query, "information_schema.columns") || strings.Contains(s.Query, "regclass") {
+			lookups = append(lookups, strings.Join(s.Args, " "))
+		}
+	}
+	want = []string{
+		`public orders`, `"public"."orders"`,
+		`public orders.2021`, `"public"."orders.2021"`,
+		`sales.eu orders`, `"sales.eu"."orders"`,
+		`"sales.eu"."totals"`,
+	}
+	if strings.Join(lookups, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lookups:\n%s\nwant:\n%s", strings.Join(lookups, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPostgresKeylessOrder(t *testing.T) {
+	db, _ := startPostgres(t, func(query string, args []string) pgAnswer {
+		switch {
+		case strings.Contains(query, "SELECT table_type FROM information_schema.tables") && args[1] == "totals":
+			return pgAnswer{Columns: []string{"table_type"}, Rows: [][]string{{"VIEW"}}}
+		case strings.Contains(query, "SELECT table_type FROM information_schema.tables"):
+			return pgAnswer{Columns: []string{"table_type"}, Rows: [][]string{{"BASE TABLE"}}}
+		case strings.Contains(query, "i.indisprimary"):
+			return pgAnswer{Columns: []string{"attname"}}
+		}
+		return pgAnswer{}
+	})
+
+	// keyless tables are paged by where their rows are stored, but never edited by it
+	if key := db.GetTableKey("public.notes"); key.Order != "ctid" || key.RowID != "" || key.Warning == "" {
+		t.Errorf("key of a keyless table = %+v", key)
+	}
+	if key := db.GetTableKey("public.totals"); key.Order != "" || !key.View {
+		t.Errorf("key of a view = %+v", key)
+	}
+}
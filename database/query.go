@@ -1,21 +1,45 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	DriverSQLite   = "sqlite"
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
 )
 
+// RowIDColumn is the alias a driver row id is selected under for tables without a primary key.
+// It's kept alongside the table data but never shown as a header
+const RowIDColumn = "termdbms_rowid"
+
 var (
 	DBMutex      sync.Mutex
 	Databases    map[string]*sql.DB
 	DriverString string
 	IsCSV        bool
+	CSVFiles     map[string]string // table name to the csv (or json) file it was imported from
+	ReadOnly     bool              // -read-only, databases are opened so nothing can be written to them
 )
 
 func init() {
 	// We keep one connection pool per database.
 	DBMutex = sync.Mutex{}
 	Databases = make(map[string]*sql.DB)
+	CSVFiles = make(map[string]string)
 }
 
 type Query interface {
@@ -24,14 +48,65 @@ type Query interface {
 }
 
 type Database interface {
-	Update(q *Update)
+	Update(q *Update) error
+	Insert(q *Insert) error
+	Delete(q *Delete) error
 	GenerateQuery(u *Update) (string, []string)
-	GetPlaceholderForDatabaseType() string
+	GetRowCondition(tableName string, values map[string]interface{}, firstPosition int) (string, []string)
+	GetPlaceholderForDatabaseType(position int) string // position is 1-based, for drivers that number their parameters
 	GetFileName() string
-	GetTableNamesQuery() string
+	GetTableNamesQuery() string // selects the name of every table and view, and whether it's a view
+	QuoteIdentifier(name string) string
+	GetTableKey(tableName string) TableKey
+	GetColumns(tableName string) ([]Column, error)
+	GetForeignKeys(tableName string) ([]ForeignKey, error)
+	GetIndexes(tableName string) ([]Index, error)
+	GetSchemaObjects() ([]SchemaObject, error)
 	GetDatabaseReference() *sql.DB
 	CloseDatabaseReference()
-	SetDatabaseReference(dbPath string)
+	SetDatabaseReference(dbPath string) error
+}
+
+// TableKey describes how a single row of a table is addressed when it gets updated
+type TableKey struct {
+	Columns []string // primary key columns, empty if the table has none
+	RowID   string   // pseudo-column (i.e. rowid) selected as RowIDColumn when there is no primary key
+	Order   string   // pseudo-column (i.e. ctid) keyless rows are paged by when there's no RowID, too unstable to edit by
+	Warning string   // set when an edit can't be guaranteed to hit exactly one row
+	View    bool     // views can be browsed but not edited
+}
+
+// Column describes a table column as it is declared in the schema
+type Column struct {
+	Name       string
+	Type       string
+	NotNull    bool
+	Default    sql.NullString
+	PrimaryKey bool
+}
+
+// ForeignKey is a reference from some columns of a table to the columns of another
+type ForeignKey struct {
+	Columns    []string
+	Table      string
+	References []string // referenced columns, in the same order as Columns
+	OnUpdate   string
+	OnDelete   string
+}
+
+// Index is an index on the columns of a table
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// SchemaObject is anything the schema defines: a table, view, index or trigger
+type SchemaObject struct {
+	Type  string
+	Name  string
+	Table string // table an index or trigger belongs to, the object itself for tables and views
+	SQL   string // CREATE statement
 }
 
 type Update struct {
@@ -49,28 +124,431 @@ func (u *Update) SetValues(v map[string]interface{}) {
 	u.v = v
 }
 
+type Insert struct {
+	v         map[string]interface{} // values of the new row by column, columns left out get their default
+	TableName string
+}
+
+func (i *Insert) GetValues() map[string]interface{} {
+	return i.v
+}
+
+func (i *Insert) SetValues(v map[string]interface{}) {
+	i.v = v
+}
+
+type Delete struct {
+	v         map[string]interface{} // anchors to ensure the right row gets deleted
+	TableName string
+}
+
+func (d *Delete) GetValues() map[string]interface{} {
+	return d.v
+}
+
+func (d *Delete) SetValues(v map[string]interface{}) {
+	d.v = v
+}
+
+// NewDatabase returns the Database implementation for the active DriverString
+func NewDatabase(fileName string, db *sql.DB) Database {
+	switch DriverString {
+	case DriverMySQL:
+		return &MySQL{
+			FileName: fileName,
+			Database: db,
+		}
+	case DriverPostgres:
+		return &Postgres{
+			FileName: fileName,
+			Database: db,
+		}
+	default:
+		return &SQLite{
+			FileName: fileName,
+			Database: db,
+		}
+	}
+}
+
+// IsServerDriver is true for drivers that connect with a connection string instead of a file path
+func IsServerDriver(driver string) bool {
+	return driver == DriverMySQL || driver == DriverPostgres
+}
+
+// QuoteTableName quotes a table name from TableIndexMap, which may be qualified with its schema
+func QuoteTableName(db Database, name string) string {
+	if schema, table := SplitTableName(db, name); schema != "" {
+		return db.QuoteIdentifier(schema) + "." + db.QuoteIdentifier(table)
+	}
+
+	return db.QuoteIdentifier(name)
+}
+
+// SplitTableName separates the schema from a table name for databases that group tables by schema. The schema
+// ends at the first dot, unless it's quoted because it has dots of its own
+func SplitTableName(db Database, name string) (schema string, table string) {
+	switch db.(type) {
+	case *Postgres:
+		if strings.HasPrefix(name, `"`) {
+			for i := 1; i < len(name)-1; i++ {
+				if name[i] != '"' {
+					continue
+				} else if name[i+1] == '"' { // doubled to escape it
+					i++
+				} else if name[i+1] == '.' {
+					return strings.ReplaceAll(name[1:i], `""`, `"`), name[i+2:]
+				} else {
+					break
+				}
+			}
+		}
+		if i := strings.Index(name, "."); i > -1 {
+			return name[:i], name[i+1:]
+		}
+	}
+
+	return "", name
+}
+
+// GetSubstringCondition builds a where condition matching rows where any of the columns contains
+// substring, ignoring case. Columns are cast to text first so numbers and dates match too
+func GetSubstringCondition(db Database, columns []string, substring string) string {
+	textType := "TEXT"
+	if _, ok := db.(*MySQL); ok {
+		textType = "CHAR"
+	}
+
+	pattern := literal("%" + strings.ToLower(substring) + "%")
+	var conditions []string
+	for _, c := range columns {
+		conditions = append(conditions, fmt.Sprintf("LOWER(CAST(%s AS %s)) LIKE %s", db.QuoteIdentifier(c), textType, pattern))
+	}
+
+	return strings.Join(conditions, " OR ")
+}
+
+// ValueLiteral writes a value as a literal for the database, for scripts that get run elsewhere
+func ValueLiteral(db Database, value interface{}) string {
+	switch conv := value.(type) {
+	case nil:
+		return "NULL"
+	case int64, int32, int:
+		return fmt.Sprintf("%d", conv)
+	case float64:
+		return floatLiteral(strconv.FormatFloat(conv, 'g', -1, 64))
+	case float32:
+		return floatLiteral(strconv.FormatFloat(float64(conv), 'g', -1, 32))
+	case bool:
+		if _, ok := db.(*SQLite); ok {
+			if conv {
+				return "1"
+			}
+			return "0"
+		}
+		return strings.ToUpper(strconv.FormatBool(conv))
+	case []byte:
+		if _, ok := db.(*Postgres); ok {
+			return fmt.Sprintf("'\\x%x'::bytea", conv)
+		}
+		return fmt.Sprintf("X'%x'", conv)
+	case time.Time:
+		return literal(conv.Format("2006-01-02 15:04:05.999999999"))
+	case string:
+		if _, ok := db.(*MySQL); ok { // backslashes are escapes in mysql strings
+			conv = strings.ReplaceAll(conv, "\\", "\\\\")
+		}
+		return literal(conv)
+	}
+
+	return literal(fmt.Sprintf("%v", value))
+}
+
+// floatLiteral keeps whole floats like 3.0 from being read back as integers
+func floatLiteral(s string) string {
+	if strings.ContainsAny(s, ".eEnN") { // fractions, exponents, NaN and Inf
+		return s
+	}
+
+	return s + ".0"
+}
+
+// CountAffectedRows runs statement in a transaction that gets rolled back, to see how many rows it would change
+// without changing them. Only for engines that roll everything back, not MySQL
+func CountAffectedRows(ctx context.Context, db Database, statement string) (int64, error) {
+	tx, err := db.GetDatabaseReference().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, statement)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
 // GetDatabaseForFile does what you think it does
-func GetDatabaseForFile(database string) *sql.DB {
+func GetDatabaseForFile(database string) (*sql.DB, error) {
 	DBMutex.Lock()
 	defer DBMutex.Unlock()
 	if db, ok := Databases[database]; ok {
-		return db
+		return db, nil
+	}
+	var (
+		db  *sql.DB
+		err error
+	)
+	if DriverString == DriverMySQL && ReadOnly {
+		db, err = openReadOnlyMySQL(database)
+	} else {
+		db, err = sql.Open(DriverString, getDataSourceName(database))
 	}
-	db, err := sql.Open(DriverString, database)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if DriverString == DriverSQLite { // the undo journal lives in temp tables, which are per connection
+		db.SetMaxOpenConns(1)
 	}
 	Databases[database] = db
-	return db
+	return db, nil
 }
 
-func ProcessSqlQueryForDatabaseType(q Query, rowData map[string]interface{}, schemaName, columnName string, db *Database) {
+// getDataSourceName opens SQLite files with mode=ro and starts PostgreSQL sessions read only in read-only mode.
+// MySQL is opened by openReadOnlyMySQL
+func getDataSourceName(name string) string {
+	if !ReadOnly {
+		return name
+	}
+
+	switch DriverString {
+	case DriverSQLite:
+		if abs, err := filepath.Abs(name); err == nil {
+			name = abs
+		}
+		name = filepath.ToSlash(name)
+		if !strings.HasPrefix(name, "/") { // C:/... on windows
+			name = "/" + name
+		}
+		return (&url.URL{Scheme: "file", Path: name, RawQuery: "mode=ro"}).String()
+	case DriverPostgres:
+		if u, err := url.Parse(name); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+			values := u.Query()
+			values.Set("default_transaction_read_only", "on")
+			u.RawQuery = values.Encode()
+			return u.String()
+		}
+		return name + " default_transaction_read_only=on"
+	}
+
+	return name
+}
+
+// mysqlReadOnlyVariables make every MySQL session read only, set on connect through the DSN. MySQL 8 only
+// knows transaction_read_only, MariaDB before 11.1 only tx_read_only
+var mysqlReadOnlyVariables = []string{"transaction_read_only", "tx_read_only"}
+
+// openReadOnlyMySQL connects with sessions that refuse to write, whatever the statement looks like. The
+// server is asked right away, to find the variable it knows
+func openReadOnlyMySQL(dsn string) (*sql.DB, error) {
+	config, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if config.Params == nil {
+		config.Params = make(map[string]string)
+	}
+
+	for _, variable := range mysqlReadOnlyVariables {
+		for _, v := range mysqlReadOnlyVariables {
+			delete(config.Params, v)
+		}
+		config.Params[variable] = "1"
+		db, err := sql.Open(DriverMySQL, config.FormatDSN())
+		if err != nil {
+			return nil, err
+		}
+		err = db.Ping()
+		if err == nil {
+			return db, nil
+		}
+		db.Close()
+		var mysqlErr *mysql.MySQLError
+		if !errors.As(err, &mysqlErr) || mysqlErr.Number != 1193 { // anything but an unknown system variable
+			return nil, err
+		}
+	}
+
+	return nil, errors.New("the server can't make sessions read only")
+}
+
+func ProcessSqlQueryForDatabaseType(q Query, rowData map[string]interface{}, schemaName, columnName string, db *Database) error {
 	switch conv := q.(type) {
 	case *Update:
 		conv.SetValues(rowData)
 		conv.TableName = schemaName
 		conv.Column = columnName
-		(*db).Update(conv)
-		break
+		return (*db).Update(conv)
+	case *Insert:
+		conv.SetValues(rowData)
+		conv.TableName = schemaName
+		return (*db).Insert(conv)
+	case *Delete:
+		conv.SetValues(rowData)
+		conv.TableName = schemaName
+		return (*db).Delete(conv)
+	}
+
+	return nil
+}
+
+// executeUpdate runs the statement built by GenerateQuery in its own transaction
+func executeUpdate(db Database, q *Update) error {
+	protoQuery, columnOrder := db.GenerateQuery(q)
+	values := getValuesInOrder(columnOrder[1:], q.GetValues())
+	return executeStatement(db, protoQuery, append([]interface{}{q.Update}, values...))
+}
+
+// executeInsert inserts a row with the given values, letting the database fill in the rest
+func executeInsert(db Database, q *Insert) error {
+	var (
+		columns      []string
+		placeholders []string
+	)
+
+	for k := range q.GetValues() {
+		if k != RowIDColumn {
+			columns = append(columns, k)
+		}
+	}
+	sort.Strings(columns) // keep track of order since maps aren't deterministic
+
+	quoted := make([]string, len(columns))
+	for i, k := range columns {
+		quoted[i] = db.QuoteIdentifier(k)
+		placeholders = append(placeholders, db.GetPlaceholderForDatabaseType(i+1))
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", QuoteTableName(db, q.TableName),
+		strings.Join(quoted, ", "), strings.Join(placeholders, ", "))
+	if len(columns) == 0 {
+		query = fmt.Sprintf("INSERT INTO %s DEFAULT VALUES;", QuoteTableName(db, q.TableName))
+		if _, ok := db.(*MySQL); ok {
+			query = fmt.Sprintf("INSERT INTO %s () VALUES ();", QuoteTableName(db, q.TableName))
+		}
 	}
+
+	return executeStatement(db, query, getValuesInOrder(columns, q.GetValues()))
+}
+
+// executeStatement runs a single statement in its own transaction, nothing is changed if it fails
+func executeStatement(db Database, query string, values []interface{}) error {
+	tx, err := db.GetDatabaseReference().Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	if _, err = stmt.Exec(values...); err != nil { // nil goes through as a real NULL, where clauses compare null-safe
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func getValuesInOrder(columns []string, values map[string]interface{}) []interface{} {
+	ordered := make([]interface{}, len(columns))
+	for i, k := range columns {
+		ordered[i] = values[k]
+	}
+
+	return ordered
+}
+
+// keyColumns returns the columns used to anchor an update. Tables without any key fall back to
+// every column, which is only safe because the drivers limit those updates to a single row
+func keyColumns(key TableKey, values map[string]interface{}) []string {
+	if key.RowID != "" {
+		return []string{RowIDColumn}
+	}
+	if len(key.Columns) > 0 {
+		return key.Columns
+	}
+
+	var columns []string
+	for k := range values {
+		if k != RowIDColumn {
+			columns = append(columns, k)
+		}
+	}
+	sort.Strings(columns) // keep track of order since maps aren't deterministic
+
+	return columns
+}
+
+// keyedWhereClause builds "col1 <op> ? AND col2 <op> ?" for the anchor columns of an update
+func keyedWhereClause(db Database, key TableKey, columns []string, nullSafeEquals string, firstPosition int) string {
+	whereBuilder := strings.Builder{}
+	for i, k := range columns {
+		column := db.QuoteIdentifier(k)
+		if k == RowIDColumn {
+			column = key.RowID
+		}
+		if i > 0 {
+			whereBuilder.WriteString(" AND ")
+		}
+		whereBuilder.WriteString(fmt.Sprintf("%s %s %s", column, nullSafeEquals, db.GetPlaceholderForDatabaseType(firstPosition+i)))
+	}
+
+	return whereBuilder.String()
+}
+
+// indexDefinition rebuilds the CREATE INDEX statement for databases that don't keep it around
+func indexDefinition(db Database, tableName string, index Index) string {
+	unique := ""
+	if index.Unique {
+		unique = "UNIQUE "
+	}
+	columns := make([]string, len(index.Columns))
+	for i, c := range index.Columns {
+		columns[i] = db.QuoteIdentifier(c)
+	}
+
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s);", unique, db.QuoteIdentifier(index.Name),
+		QuoteTableName(db, tableName), strings.Join(columns, ", "))
+}
+
+// tableDefinition rebuilds a CREATE TABLE statement from the columns of a table, for databases
+// that can't hand one back
+func tableDefinition(db Database, tableName string, columns []Column) string {
+	var (
+		lines []string
+		key   []string
+	)
+
+	for _, c := range columns {
+		line := fmt.Sprintf("    %s %s", db.QuoteIdentifier(c.Name), c.Type)
+		if c.NotNull {
+			line += " NOT NULL"
+		}
+		if c.Default.Valid {
+			line += " DEFAULT " + c.Default.String
+		}
+		lines = append(lines, line)
+		if c.PrimaryKey {
+			key = append(key, db.QuoteIdentifier(c.Name))
+		}
+	}
+	if len(key) > 0 {
+		lines = append(lines, fmt.Sprintf("    PRIMARY KEY (%s)", strings.Join(key, ", ")))
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n);", QuoteTableName(db, tableName), strings.Join(lines, ",\n"))
 }
@@ -3,7 +3,6 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"log"
 	"strings"
 )
 
@@ -12,38 +11,8 @@ type SQLite struct {
 	Database *sql.DB
 }
 
-func (db *SQLite) Update(q *Update) {
-	protoQuery, columnOrder := db.GenerateQuery(q)
-	values := make([]interface{}, len(columnOrder))
-	updateValues := q.GetValues()
-	for i, v := range columnOrder {
-		var u interface{}
-		if i == 0 {
-			u = q.Update
-		} else {
-			u = updateValues[v]
-		}
-
-		if u == nil {
-			u = "NULL"
-		}
-
-		values[i] = u
-	}
-	tx, err := db.GetDatabaseReference().Begin()
-	if err != nil {
-		log.Fatal(err)
-	}
-	stmt, err := tx.Prepare(protoQuery)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer stmt.Close()
-	stmt.Exec(values...)
-	err = tx.Commit()
-	if err != nil {
-		log.Fatal(err)
-	}
+func (db *SQLite) Update(q *Update) error {
+	return executeUpdate(db, q)
 }
 
 func (db *SQLite) GetFileName() string {
@@ -56,27 +25,106 @@ func (db *SQLite) GetDatabaseReference() *sql.DB {
 
 func (db *SQLite) CloseDatabaseReference() {
 	db.GetDatabaseReference().Close()
+	DBMutex.Lock()
+	delete(Databases, db.FileName)
+	DBMutex.Unlock()
 	db.Database = nil
 }
 
-func (db *SQLite) SetDatabaseReference(dbPath string) {
-	database := GetDatabaseForFile(dbPath)
+func (db *SQLite) SetDatabaseReference(dbPath string) error {
+	database, err := GetDatabaseForFile(dbPath)
+	if err != nil {
+		return err
+	}
 	db.FileName = dbPath
 	db.Database = database
+
+	return nil
 }
 
-func (db SQLite) GetPlaceholderForDatabaseType() string {
+func (db SQLite) GetPlaceholderForDatabaseType(position int) string {
 	return "?"
 }
 
 func (db SQLite) GetTableNamesQuery() string {
-	val := "SELECT name FROM "
+	val := "SELECT name, type = 'view' FROM "
 	val += "sqlite_master"
-	val += " WHERE type='table'"
+	val += " WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%'"
 
 	return val
 }
 
+func (db SQLite) QuoteIdentifier(name string) string {
+	return "\"" + strings.ReplaceAll(name, "\"", "\"\"") + "\""
+}
+
+// GetTableKey uses the primary key of the table if there is one, otherwise the implicit rowid
+func (db *SQLite) GetTableKey(tableName string) TableKey {
+	var (
+		key        TableKey
+		objectType string
+		definition sql.NullString
+	)
+
+	rows, err := db.GetDatabaseReference().Query("SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk", tableName)
+	if err != nil {
+		key.Warning = fmt.Sprintf("Could not read primary key of %s: %v", tableName, err)
+		return key
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var column string
+		rows.Scan(&column)
+		key.Columns = append(key.Columns, column)
+	}
+
+	db.GetDatabaseReference().QueryRow("SELECT type, sql FROM sqlite_master WHERE name = ?", tableName).Scan(&objectType, &definition)
+	withoutRowID := strings.Contains(strings.ToUpper(definition.String), "WITHOUT ROWID")
+
+	if objectType == "view" { // no rowid to select either
+		return TableKey{
+			View:    true,
+			Warning: fmt.Sprintf("%s is a view and can't be edited.", tableName),
+		}
+	}
+
+	if len(key.Columns) > 0 {
+		return key
+	}
+
+	if withoutRowID { // should be impossible, sqlite requires a primary key for these
+		key.Warning = fmt.Sprintf("%s is a WITHOUT ROWID table with no usable key, edits may hit more than one row.", tableName)
+		return key
+	}
+
+	key.RowID = "rowid"
+	key.Warning = fmt.Sprintf("%s has no primary key, edits are matched by rowid.", tableName)
+	return key
+}
+
+func (db *SQLite) GetColumns(tableName string) ([]Column, error) {
+	var columns []Column
+
+	rows, err := db.GetDatabaseReference().Query("SELECT name, type, \"notnull\", dflt_value, pk FROM pragma_table_info(?) ORDER BY cid", tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			c  Column
+			pk int
+		)
+		if err = rows.Scan(&c.Name, &c.Type, &c.NotNull, &c.Default, &pk); err != nil {
+			return nil, err
+		}
+		c.PrimaryKey = pk > 0
+		columns = append(columns, c)
+	}
+
+	return columns, rows.Err()
+}
+
 func (db *SQLite) GenerateQuery(u *Update) (string, []string) {
 	var (
 		query         string
@@ -84,25 +132,126 @@ func (db *SQLite) GenerateQuery(u *Update) (string, []string) {
 		valueOrder    []string
 	)
 
-	placeholder := db.GetPlaceholderForDatabaseType()
-
 	querySkeleton = fmt.Sprintf("UPDATE %s"+
-		" SET %s=%s ", u.TableName, u.Column, placeholder)
+		" SET %s=%s", db.QuoteIdentifier(u.TableName), db.QuoteIdentifier(u.Column), db.GetPlaceholderForDatabaseType(1))
 	valueOrder = append(valueOrder, u.Column)
 
-	whereBuilder := strings.Builder{}
-	whereBuilder.WriteString(" WHERE ")
-	uLen := len(u.GetValues())
-	i := 0
-	for k := range u.GetValues() { // keep track of order since maps aren't deterministic
-		assertion := fmt.Sprintf("%s=%s ", k, placeholder)
-		valueOrder = append(valueOrder, k)
-		whereBuilder.WriteString(assertion)
-		if uLen > 1 && i < uLen-1 {
-			whereBuilder.WriteString("AND ")
+	where, columns := db.GetRowCondition(u.TableName, u.GetValues(), 2)
+	valueOrder = append(valueOrder, columns...)
+	query = querySkeleton + " WHERE " + where + ";"
+	return query, valueOrder
+}
+
+// GetRowCondition builds the where clause that singles out the row with the given values
+func (db *SQLite) GetRowCondition(tableName string, values map[string]interface{}, firstPosition int) (string, []string) {
+	key := db.GetTableKey(tableName)
+	columns := keyColumns(key, values)
+	return keyedWhereClause(db, key, columns, "IS", firstPosition), columns
+}
+
+func (db *SQLite) Insert(q *Insert) error {
+	return executeInsert(db, q)
+}
+
+func (db *SQLite) Delete(q *Delete) error {
+	where, columns := db.GetRowCondition(q.TableName, q.GetValues(), 1)
+	return executeStatement(db, fmt.Sprintf("DELETE FROM %s WHERE %s;", db.QuoteIdentifier(q.TableName), where),
+		getValuesInOrder(columns, q.GetValues()))
+}
+
+func (db *SQLite) GetForeignKeys(tableName string) ([]ForeignKey, error) {
+	var keys []ForeignKey
+
+	rows, err := db.GetDatabaseReference().Query("SELECT id, \"table\", \"from\", \"to\", on_update, on_delete"+
+		" FROM pragma_foreign_key_list(?) ORDER BY id, seq", tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	last := -1
+	for rows.Next() {
+		var (
+			id                 int
+			table, from        string
+			to                 sql.NullString // null when it references the primary key
+			onUpdate, onDelete string
+		)
+		if err = rows.Scan(&id, &table, &from, &to, &onUpdate, &onDelete); err != nil {
+			return nil, err
 		}
-		i++
+		if id != last { // one row per column of the key
+			keys = append(keys, ForeignKey{
+				Table:    table,
+				OnUpdate: onUpdate,
+				OnDelete: onDelete,
+			})
+			last = id
+		}
+		k := &keys[len(keys)-1]
+		k.Columns = append(k.Columns, from)
+		k.References = append(k.References, to.String)
 	}
-	query = querySkeleton + strings.TrimSpace(whereBuilder.String()) + ";"
-	return query, valueOrder
+
+	return keys, rows.Err()
+}
+
+func (db *SQLite) GetIndexes(tableName string) ([]Index, error) {
+	var indexes []Index
+
+	rows, err := db.GetDatabaseReference().Query("SELECT name, \"unique\" FROM pragma_index_list(?) ORDER BY name", tableName)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() { // read these up front, sqlite only gets one connection
+		var i Index
+		if err = rows.Scan(&i.Name, &i.Unique); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		indexes = append(indexes, i)
+	}
+	rows.Close()
+
+	for n := range indexes {
+		rows, err = db.GetDatabaseReference().Query("SELECT name FROM pragma_index_info(?) ORDER BY seqno", indexes[n].Name)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var column sql.NullString // null for expressions
+			rows.Scan(&column)
+			if !column.Valid {
+				column.String = "<expression>"
+			}
+			indexes[n].Columns = append(indexes[n].Columns, column.String)
+		}
+		rows.Close()
+	}
+
+	return indexes, nil
+}
+
+func (db *SQLite) GetSchemaObjects() ([]SchemaObject, error) {
+	var objects []SchemaObject
+
+	rows, err := db.GetDatabaseReference().Query("SELECT type, name, tbl_name, sql FROM sqlite_master" +
+		" WHERE name NOT LIKE 'sqlite_%'" +
+		" ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'view' THEN 1 WHEN 'index' THEN 2 ELSE 3 END, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			o          SchemaObject
+			definition sql.NullString
+		)
+		if err = rows.Scan(&o.Type, &o.Name, &o.Table, &definition); err != nil {
+			return nil, err
+		}
+		o.SQL = definition.String
+		objects = append(objects, o)
+	}
+
+	return objects, rows.Err()
 }
@@ -7,6 +7,10 @@ require (
 	github.com/charmbracelet/bubbles v0.9.0
 	github.com/charmbracelet/bubbletea v0.18.0
 	github.com/charmbracelet/lipgloss v0.4.0
+	github.com/dolthub/go-mysql-server v0.14.0
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/jackc/pgproto3/v2 v2.3.3
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-isatty v0.0.14-0.20210829144114-504425e14f74 // indirect
 	github.com/mattn/go-runewidth v0.0.13
 	github.com/muesli/reflow v0.3.0
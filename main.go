@@ -4,8 +4,6 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
-	"io/fs"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,6 +13,8 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 	"github.com/mathaou/termdbms/database"
 	"github.com/muesli/termenv"
 	_ "modernc.org/sqlite"
@@ -22,22 +22,46 @@ import (
 
 type DatabaseType string
 
+// pathList collects every -p, so several csv files can be opened at once
+type pathList []string
+
+func (p *pathList) String() string {
+	return strings.Join(*p, ", ")
+}
+
+func (p *pathList) Set(s string) error {
+	*p = append(*p, s)
+	return nil
+}
+
 const (
 	debugPath = "" // set to whatever hardcoded path for testing
 )
 
 const (
-	DatabaseSQLite DatabaseType = "sqlite"
-	DatabaseMySQL  DatabaseType = "mysql"
+	DatabaseSQLite   DatabaseType = database.DriverSQLite
+	DatabaseMySQL    DatabaseType = database.DriverMySQL
+	DatabasePostgres DatabaseType = database.DriverPostgres
 )
 
 var (
 	debug        bool
 	path         string
+	paths        pathList
+	connection   string
 	databaseType string
 	theme        string
 	help         bool
 	ascii        bool
+	query        string
+	output       string
+	delimiter    string
+	quote        string
+	comment      string
+	noHeader     bool
+	encoding     string
+	skip         int
+	readOnly     bool
 )
 
 func main() {
@@ -50,8 +74,12 @@ func main() {
 		}
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "diff" { // termdbms diff a.db b.db
+		os.Exit(runDiff(os.Args[2:]))
+	}
+
 	argLength := len(os.Args[1:])
-	if (argLength > 4 || argLength == 0) && !debug {
+	if argLength == 0 && !debug {
 		fmt.Printf("ERROR: Invalid number of arguments supplied: %d\n", argLength)
 		flag.Usage()
 		os.Exit(1)
@@ -59,12 +87,26 @@ func main() {
 
 	// flags declaration using flag package
 	flag.StringVar(&databaseType, "d", string(DatabaseSQLite), "Specifies the SQL driver to use. Defaults to SQLite.")
-	flag.StringVar(&path, "p", "", "Path to the database file.")
+	flag.Var(&paths, "p", "Path to the database file. Repeat it, or give a glob or directory, to open several csv files.")
+	flag.StringVar(&connection, "c", "", "Connection string for server databases (mysql/postgres).")
 	flag.StringVar(&theme, "t", "default", "sets the color theme of the app.")
 	flag.BoolVar(&help, "h", false, "Prints the help message.")
 	flag.BoolVar(&ascii, "a", false, "Denotes that the app should render with minimal styling to remove ANSI sequences.")
+	flag.StringVar(&query, "q", "", "Runs a query without starting the app and prints the result. - reads it from stdin.")
+	flag.StringVar(&output, "o", OutputTable, "Output format for -q (csv, json, table, markdown).")
+	flag.StringVar(&delimiter, "delimiter", "", "Field delimiter of a csv file. Defaults to , or the one for .tsv/.psv files.")
+	flag.StringVar(&quote, "quote", "\"", "Quote character of a csv file, empty for none.")
+	flag.StringVar(&comment, "comment", "", "Lines of a csv file starting with this are skipped.")
+	flag.BoolVar(&noHeader, "no-header", false, "The first line of a csv file is data, columns get generated names.")
+	flag.StringVar(&encoding, "encoding", EncodingUTF8, "Encoding of a csv file (utf-8, latin-1).")
+	flag.IntVar(&skip, "skip", 0, "Lines to skip at the start of a csv file.")
+	flag.BoolVar(&readOnly, "read-only", false, "Opens the database read only, without a working copy. Edits are disabled.")
 
 	flag.Parse()
+	paths = append(paths, flag.Args()...) // -p a.csv b.csv
+	if len(paths) > 0 {
+		path = paths[0]
+	}
 
 	handleFlags()
 
@@ -90,50 +132,205 @@ func main() {
 		theme = "default"
 	}
 
+	if database.IsServerDriver(databaseType) { // nothing to copy or convert, edits go straight to the server
+		db := openDatabase(connection)
+		if err := db.Ping(); err != nil {
+			fmt.Printf("ERROR: Could not connect to %s database: %v\n", databaseType, err)
+			os.Exit(1)
+		}
+		if query != "" {
+			os.Exit(runQuery(connection, db, query, output))
+		}
+		start(connection, "", connection, db, c, nil)
+		return
+	}
+
+	csvFiles, err := getCSVFiles(paths)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	database.IsCSV = len(csvFiles) > 0 // json files go through the same import and write back
+
 	// gets a sqlite instance for the database file
-	if exists, _ := FileExists(path); exists {
+	if exists, _ := FileExists(path); exists && !database.IsCSV {
 		fmt.Printf("ERROR: Database file could not be found at %s\n", path)
 		os.Exit(1)
 	}
 
-	if valid, _ := Exists(HiddenTmpDirectoryName); valid {
-		filepath.Walk(HiddenTmpDirectoryName, func(path string, info fs.FileInfo, err error) error {
-			if strings.HasPrefix(path, fmt.Sprintf("%s/.", HiddenTmpDirectoryName)) && !info.IsDir() {
-				os.Remove(path) // remove all temp databaess
-			}
-			return nil
-		})
-	} else {
-		os.Mkdir(HiddenTmpDirectoryName, 0o777)
+	// working copies and imported files go in a directory of this session, a read only database is used as it is
+	if !database.ReadOnly || database.IsCSV {
+		if err = StartSession(); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	database.IsCSV = strings.HasSuffix(path, ".csv")
 	dst := path
-	if database.IsCSV { // convert the csv to sql, then run the sql through a database
-		sqlFile := strings.TrimSuffix(path, ".csv")
-		sqlFile = filepath.Base(sqlFile)
-		path = Convert(path, sqlFile, true)
-		csvDBFile := HiddenTmpDirectoryName + "/" + sqlFile + ".db"
-		os.Create(csvDBFile)
-		dst, _ = filepath.Abs(csvDBFile)
-		d, _ := sql.Open(database.DriverString, dst)
-		f, _ := os.Open(path)
-		b, _ := ioutil.ReadAll(f)
-		query := string(b)
-		_, err := d.Exec(query)
+	if database.IsCSV {
+		if dst, err = importCSVFiles(csvFiles, SessionDirectory); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			exit(1)
+		}
+	}
+
+	if query != "" { // scripts work on the file itself, not a copy
+		exit(runQuery(dst, openDatabase(dst), query, output))
+	}
+
+	if database.ReadOnly { // browse the file itself, there are no pending changes
+		start(dst, "", path, openDatabase(dst), c, nil)
+		EndSession()
+		return
+	}
+
+	base := dst // edits go to a copy, what differs from this are the pending changes
+	if dst, _, err = CopyFile(dst); err != nil {
+		fmt.Printf("ERROR: Could not make a working copy of %s: %v\n", base, err)
+		exit(1)
+	}
+
+	originals := csvFiles
+	if !database.IsCSV {
+		originals = []string{path}
+	}
+	if err = WriteSessionManifest(originals, base, dst); err != nil {
+		fmt.Printf("ERROR: Could not write the session manifest: %v\n", err)
+		exit(1)
+	}
+
+	db := openDatabase(dst)
+	start(dst, base, path, db, c, getRecoverableSessions(originals))
+	EndSession()
+}
+
+// getRecoverableSessions are the crashed sessions that were editing the same files. The others are kept until
+// they're opened again
+func getRecoverableSessions(originals []string) []CrashedSession {
+	var sessions []CrashedSession
+	crashed, _ := GetCrashedSessions()
+	for _, s := range crashed {
+		if s.IsFor(originals) {
+			sessions = append(sessions, s)
+		}
+	}
+
+	return sessions
+}
+
+// openDatabase gets the database for a file or connection string, exiting if the driver can't open it
+func openDatabase(name string) *sql.DB {
+	db, err := database.GetDatabaseForFile(name)
+	if err != nil {
+		fmt.Printf("ERROR: Could not open %s: %v\n", name, err)
+		exit(1)
+	}
+
+	return db
+}
+
+// exit ends the session before exiting, os.Exit skips deferred calls
+func exit(code int) {
+	EndSession()
+	os.Exit(code)
+}
+
+// getCSVFiles expands the -p paths into the csv (and json) files to open. Globs and directories take every csv,
+// tsv, psv, json and ndjson file they match. A single path that isn't one of those is a database, and nil is
+// returned for it
+func getCSVFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		if strings.ContainsAny(p, "*?[") {
+			matches, err := filepath.Glob(p)
+			if err != nil {
+				return nil, err
+			}
+			for _, match := range matches {
+				if IsDelimitedFile(match) || IsJSONFile(match) {
+					files = append(files, match)
+				}
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no csv or json files match %s", p)
+			}
+			continue
+		}
+
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return nil, err
+			}
+			found := false
+			for _, e := range entries {
+				if !e.IsDir() && (IsDelimitedFile(e.Name()) || IsJSONFile(e.Name())) {
+					files = append(files, filepath.Join(p, e.Name()))
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("no csv or json files in %s", p)
+			}
+			continue
+		}
+
+		if IsDelimitedFile(p) || IsJSONFile(p) {
+			files = append(files, p)
+		} else if len(paths) > 1 {
+			return nil, fmt.Errorf("%s is not a csv, tsv, psv or json file, only those can be opened together", p)
+		}
+	}
+
+	return files, nil
+}
+
+// importCSVFiles reads every file into a table of its own in one temporary database in dir, so they can be joined.
+// Tables are named after their files
+func importCSVFiles(files []string, dir string) (string, error) {
+	var (
+		tables []string
+		seen   = map[string]bool{}
+	)
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+		table := name
+		for n := 2; seen[strings.ToLower(table)]; n++ { // same name in different directories
+			table = fmt.Sprintf("%s_%d", name, n)
+		}
+		seen[strings.ToLower(table)] = true
+		database.CSVFiles[table] = f
+		tables = append(tables, table)
+	}
+
+	csvDBFile := filepath.Join(dir, tables[0]+".db")
+	os.Remove(csvDBFile) // left over from an earlier run
+	dst, _ := filepath.Abs(csvDBFile)
+	d, err := sql.Open(database.DriverString, dst)
+	if err != nil {
+		return "", err
+	}
+	defer d.Close()
+
+	for i, f := range files {
+		if IsJSONFile(f) {
+			err = ImportJSON(d, f, tables[i])
+		} else {
+			err = ImportCSV(d, f, tables[i], true, CSVFormat)
+		}
 		if err != nil {
-			fmt.Printf("%v", err)
-			os.Exit(1)
+			return "", fmt.Errorf("Could not import %s:\n%v", f, err)
 		}
-		d.Close()
-		os.Remove(path) // this deletes the converted .sql file
 	}
 
-	dst, _, _ = CopyFile(dst)
+	return dst, nil
+}
 
-	db := database.GetDatabaseForFile(dst)
+// start initializes the model for the working database and hands it to bubbletea. base is the file the working
+// copy was made from, if there is one. The working copies of crashed sessions in recovery are offered first
+func start(dst, base, original string, db *sql.DB, c *sql.Rows, recovery []CrashedSession) {
 	defer func() {
-		if db == nil {
+		if db != nil {
 			db.Close()
 		}
 	}()
@@ -141,11 +338,12 @@ func main() {
 	// initializes the model used by bubbletea
 	m := GetNewModel(dst, db)
 	InitialModel = &m
-	InitialModel.InitialFileName = path
+	InitialModel.InitialFileName = original
+	InitialModel.BaseFileName = base
 	err := InitialModel.SetModel(c, db)
 	if err != nil {
 		fmt.Printf("%v", err)
-		os.Exit(1)
+		exit(1)
 	}
 
 	// creates the program
@@ -153,14 +351,21 @@ func main() {
 		tea.WithAltScreen(),
 		tea.WithMouseAllMotion())
 
+	InitialModel.Recovery = recovery
+	InitialModel.AskRecovery()
+
 	if err := Program.Start(); err != nil {
 		fmt.Printf("ERROR: Error initializing the sqlite viewer: %v", err)
-		os.Exit(1)
+		exit(1)
 	}
 }
 
 func handleFlags() {
-	if path == "" && !debug {
+	if database.IsServerDriver(databaseType) && connection == "" {
+		connection = path // older invocations passed the mysql DSN through -p
+	}
+
+	if path == "" && connection == "" && !debug {
 		fmt.Printf("ERROR: no path for database.\n")
 		flag.Usage()
 		os.Exit(1)
@@ -176,17 +381,49 @@ func handleFlags() {
 		lipgloss.SetColorProfile(termenv.Ascii)
 	}
 
-	if path != "" && !IsUrl(path) {
+	if path != "" && !database.IsServerDriver(databaseType) && !strings.ContainsAny(path, "*?[") && !IsUrl(path) {
 		fmt.Printf("ERROR: Invalid path %s\n", path)
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	if databaseType != string(DatabaseMySQL) &&
+		databaseType != string(DatabasePostgres) &&
 		databaseType != string(DatabaseSQLite) {
 		fmt.Printf("Invalid database driver specified: %s", databaseType)
 		os.Exit(1)
 	}
 
 	database.DriverString = databaseType
+	database.ReadOnly = readOnly
+
+	if err := setCSVFormat(); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+// setCSVFormat reads the csv flags into CSVFormat. Without -delimiter it comes from the extension of each file
+func setCSVFormat() (err error) {
+	if CSVFormat.Delimiter, err = ParseCSVCharacter(delimiter); err != nil {
+		return fmt.Errorf("invalid delimiter: %v", err)
+	}
+	if CSVFormat.Quote, err = ParseCSVCharacter(quote); err != nil {
+		return fmt.Errorf("invalid quote: %v", err)
+	}
+	if (CSVFormat.Delimiter != 0 && CSVFormat.Delimiter == CSVFormat.Quote) || CSVFormat.Delimiter == '\n' || CSVFormat.Delimiter == '\r' {
+		return fmt.Errorf("invalid delimiter %q", CSVFormat.Delimiter)
+	}
+	if CSVFormat.Encoding, err = ParseCSVEncoding(encoding); err != nil {
+		return err
+	}
+	if skip < 0 {
+		return fmt.Errorf("invalid number of lines to skip: %d", skip)
+	}
+	CSVFormat.Comment = comment
+	CSVFormat.NoHeader = noHeader
+	CSVFormat.Skip = skip
+
+	return nil
 }
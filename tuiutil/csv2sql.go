@@ -1,14 +1,16 @@
 package tuiutil
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/csv"
+	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
-	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 /*
@@ -30,147 +32,343 @@ import (
                                    to display the help info and also still call flags.Usage()
    updated: 09 Dec 2014 - minor tidy up and first 'release' provided on GitHub
    updated: 27 Aug 2016 - table name and csv file help output minior changes. Minor cosmetic stuff. Version 1.1
+
+   termdbms: the csv is now read straight into a database with typed columns instead of going through a .sql file
 */
 
-func SQLFileName(csvFileName string) string {
-	// include the name of the csv file from command line (ie csvFileName)
-	// remove any path etc
-	var justFileName = filepath.Base(csvFileName)
-	// get the files extension too
-	var extension = filepath.Ext(csvFileName)
-	// remove the file extension from the filename
-	justFileName = justFileName[0 : len(justFileName)-len(extension)]
-
-	sqlOutFile := "./.termdbms/SQL-" + justFileName + ".sql"
-	return sqlOutFile
+const (
+	CSVSampleSize   = 1000 // rows looked at to guess the type of each column
+	MaxImportErrors = 10   // an import stops reporting bad rows after this many
+)
+
+const (
+	csvDateLayout     = "2006-01-02"
+	csvDateTimeLayout = "2006-01-02 15:04:05.999999999"
+)
+
+// decimalType is a DECIMAL or NUMERIC column with a scale
+var decimalType = regexp.MustCompile(`(?i)^(?:DECIMAL|NUMERIC)\(\s*\d+\s*,\s*(\d+)\s*\)$`)
+
+// CSVImportError is a row of a csv file that couldn't be imported
+type CSVImportError struct {
+	Line int
+	Err  error
+}
+
+func (e *CSVImportError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// CSVImportErrors are all the bad rows of an import, nothing gets imported if there are any
+type CSVImportErrors []*CSVImportError
+
+func (e CSVImportErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, v := range e {
+		lines[i] = v.Error()
+	}
+	if len(e) == MaxImportErrors {
+		lines = append(lines, "...")
+	}
+
+	return strings.Join(lines, "\n")
 }
 
-func Convert(csvFileName, tableName string, keepOrigCols bool) string {
-	// check we have a table name and csv file to work with - otherwise abort
+// ImportCSV reads a delimited file into a new table. The first line names the columns (unless options.NoHeader is
+// set), and the first CSVSampleSize rows decide whether each column is an INTEGER, REAL, DECIMAL, DATE, DATETIME or
+// TEXT. A column only gets a type when every sampled value is written the way termdbms writes that type back, and
+// values past the sample that aren't are kept as written, so the file survives a round trip. Empty fields are NULL.
+// Rows go in through a prepared statement in one transaction, so a bad row means nothing gets imported
+func ImportCSV(db *sql.DB, csvFileName, tableName string, keepOrigCols bool, options CSVOptions) error {
 	if csvFileName == "" || tableName == "" {
-		return ""
+		return errors.New("no csv file or table name to import")
 	}
 
-	// open the CSV file - name provided via command line input - handle 'file'
 	file, err := os.Open(csvFileName)
-	// error - if we have one exit as CSV file not right
 	if err != nil {
-		fmt.Printf("ERROR: %s\n", err)
-		os.Exit(-3)
+		return err
 	}
-	// now file is open - defer the close of CSV file handle until we return
 	defer file.Close()
-	// connect a CSV reader to the file handle - which is the actual opened
-	// CSV file
-	// TODO : is there an error from this to check?
-	reader := csv.NewReader(file)
 
-	sqlOutFile := SQLFileName(csvFileName)
+	options = options.For(csvFileName)
+	reader := NewCSVReader(file, options)
+	if err = reader.Skip(options.Skip); err != nil && err != io.EOF {
+		return err
+	}
+
+	var (
+		problems CSVImportErrors
+		sample   [][]string
+		lines    []int
+	)
+
+	first, line, err := reader.Read()
+	if err == io.EOF {
+		return errors.New("the file is empty")
+	} else if err != nil {
+		return err
+	}
+	columns := csvColumnNames(first, keepOrigCols)
+	if options.NoHeader {
+		for i := range columns {
+			columns[i] = fmt.Sprintf("column_%d", i+1)
+		}
+		sample = append(sample, first)
+		lines = append(lines, line)
+	}
+
+	// next reads a record and the line it started on. Rows with the wrong number of fields (or bad quoting) are
+	// noted and skipped, anything else stops the import
+	next := func() ([]string, int, error) {
+		for {
+			record, line, err := reader.Read()
+			if err == nil && len(record) != len(columns) {
+				err = &CSVImportError{
+					Line: line,
+					Err:  fmt.Errorf("wrong number of fields, expected %d but got %d", len(columns), len(record)),
+				}
+			}
+			if importError, ok := err.(*CSVImportError); ok {
+				problems = append(problems, importError)
+				if len(problems) == MaxImportErrors {
+					return nil, 0, problems
+				}
+				continue
+			}
+			return record, line, err
+		}
+	}
+
+	for len(sample) < CSVSampleSize {
+		record, l, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		sample = append(sample, record)
+		lines = append(lines, l)
+	}
+
+	definitions := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	types := make([]string, len(columns))
+	for i, c := range columns {
+		types[i] = inferColumnType(sample, i)
+		definitions[i] = quoteIdentifier(c) + " " + types[i]
+		placeholders[i] = "?"
+	}
 
-	// open the new file using the name we obtained above - handle 'filesql'
-	filesql, err := os.Create(sqlOutFile)
-	// error - if we have one when trying open & create the new file
+	tx, err := db.Begin()
 	if err != nil {
-		return ""
-	}
-	// now new file is open - defer the close of the file handle until we return
-	defer filesql.Close()
-	// attach the opened new sql file handle to a buffered file writer
-	// the buffered file writer has the handle 'sqlFileBuffer'
-	sqlFileBuffer := bufio.NewWriter(filesql)
-
-	//-------------------------------------------------------------------------
-	// prepare to read the each line of the CSV file - and write out to the SQl
-	//-------------------------------------------------------------------------
-	// track the number of lines in the csv file
-	lineCount := 0
-
-	// create a buffer to hold each line of the SQL file as we build it
-	// handle to this buffer is called 'strbuffer'
-	var strbuffer bytes.Buffer
-
-	// START - processing of each line in the CSV input file
-	//-------------------------------------------------------------------------
-	// loop through the csv file until EOF - or until we hit an error in parsing it.
-	// Data is read in for each line of the csv file and held in the variable
-	// 'record'.  Build a string for each line - wrapped with the SQL and
-	// then output to the SQL file writer in its completed new form
-	//-------------------------------------------------------------------------
-	for {
-		record, err := reader.Read()
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	quotedTable := quoteIdentifier(tableName)
+	if _, err = tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", quotedTable, strings.Join(definitions, ", "))); err != nil {
+		return err
+	}
+	statement, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s VALUES (%s)", quotedTable, strings.Join(placeholders, ", ")))
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	values := make([]interface{}, len(columns))
+	insert := func(record []string, l int) error {
+		for i, field := range record {
+			values[i] = getCSVValue(field, types[i])
+		}
+		if _, err := statement.Exec(values...); err != nil {
+			problems = append(problems, &CSVImportError{
+				Line: l,
+				Err:  err,
+			})
+			if len(problems) == MaxImportErrors {
+				return problems
+			}
+		}
+		return nil
+	}
 
-		// if we hit end of file (EOF) or another unexpected error
+	for i, record := range sample {
+		if err = insert(record, lines[i]); err != nil {
+			return err
+		}
+	}
+	for {
+		record, l, err := next()
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return ""
+			return err
+		}
+		if err = insert(record, l); err != nil {
+			return err
 		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
 
-		// if we are processing the first line - use the record field contents
-		// as the SQL table column names - add to the temp string 'strbuffer'
-		// use the tablename provided by the user
-		if lineCount == 0 {
-			strbuffer.WriteString("CREATE TABLE " + tableName + " (")
+	return tx.Commit()
+}
+
+// csvColumnNames makes a usable column name out of every header field. Empty names get numbered and
+// repeated ones get a suffix
+func csvColumnNames(header []string, keepOrigCols bool) []string {
+	columns := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if !keepOrigCols {
+			name = cleanHeader(name)
+		}
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
 		}
+		unique := name
+		for n := 2; seen[strings.ToLower(unique)]; n++ { // sqlite column names aren't case sensitive
+			unique = fmt.Sprintf("%s_%d", name, n)
+		}
+		seen[strings.ToLower(unique)] = true
+		columns[i] = unique
+	}
+
+	return columns
+}
 
-		// if any line except the first one :
-		// print the start of the SQL insert statement for the record
-		// and  - add to the temp string 'strbuffer'
-		// use the tablename provided by the user
-		if lineCount > 0 {
-			strbuffer.WriteString("INSERT INTO " + tableName + " VALUES (")
+// inferColumnType is the first of INTEGER, REAL, DECIMAL(18,s), DATE and DATETIME that every value of a column in
+// the sample is written as, see isCanonicalField. The scale of a DECIMAL comes from the first value. Anything else,
+// like 007, 1e3 or 2021-1-2, and columns without any values are TEXT
+func inferColumnType(sample [][]string, column int) string {
+	var fields []string
+	for _, record := range sample {
+		if record[column] != "" {
+			fields = append(fields, record[column])
 		}
-		// loop through each of the csv lines individual fields held in 'record'
-		// len(record) tells us how many fields are on this line - so we loop right number of times
-		for i := 0; i < len(record); i++ {
-			// if we are processing the first line used for the table column name - update the
-			// record field contents to remove the characters: space | - + @ # / \ : ( ) '
-			// from the SQL table column names. Can be overridden on command line with '-k true'
-			if (lineCount == 0) && (keepOrigCols == false) {
-				// call the function cleanHeader to do clean up on this field
-				record[i] = cleanHeader(record[i])
-			}
-			// if a csv record field is empty or has the text "NULL" - replace it with actual NULL field in SQLite
-			// otherwise just wrap the existing content with ''
-			// TODO : make sure we don't try to create a 'NULL' table column name?
-			if len(record[i]) == 0 || record[i] == "NULL" {
-				strbuffer.WriteString("NULL")
-			} else {
-				strbuffer.WriteString("\"" + record[i] + "\"")
-			}
-			// if we have not reached the last record yet - add a coma also to the output
-			if i < len(record)-1 {
-				strbuffer.WriteString(",")
+	}
+	if len(fields) == 0 {
+		return "TEXT"
+	}
+
+	candidates := []string{"INTEGER", "REAL"}
+	if i := strings.IndexByte(fields[0], '.'); i > -1 && i < len(fields[0])-1 {
+		candidates = append(candidates, fmt.Sprintf("DECIMAL(18,%d)", len(fields[0])-i-1))
+	}
+	candidates = append(candidates, "DATE", "DATETIME")
+
+	for _, typeName := range candidates {
+		canonical := true
+		for _, field := range fields {
+			if !isCanonicalField(field, typeName) {
+				canonical = false
+				break
 			}
 		}
-		// end of the line - so output SQL format required ');' and newline
-		strbuffer.WriteString(");\n")
-		// line of SQL is complete - so push out to the new SQL file
-		bWritten, err := sqlFileBuffer.WriteString(strbuffer.String())
-		// check it wrote data ok - otherwise report the error giving the line number affected
-		if (err != nil) || (bWritten != len(strbuffer.Bytes())) {
-			return ""
+		if canonical {
+			return typeName
+		}
+	}
+
+	return "TEXT"
+}
+
+// getCSVValue is what a field is stored as in a column of typeName. Fields that wouldn't be written back the same
+// way go in as blobs, which skip the column affinity, so they stay as written
+func getCSVValue(field, typeName string) interface{} {
+	if field == "" {
+		return nil
+	} else if !isCanonicalField(field, typeName) {
+		return []byte(field)
+	} else if typeName == "REAL" {
+		f, _ := strconv.ParseFloat(field, 64)
+		return f // parsed here rather than by sqlite, so it's the float that FormatFloat wrote
+	}
+
+	return field // the column affinity takes care of numbers
+}
+
+// isCanonicalField is true when field is written back as it is once stored in a column of typeName: integers without
+// leading zeros or a plus sign, floats as FormatFloat writes them, decimals with the scale of their column and dates
+// the way FormatCSVTime writes them
+func isCanonicalField(field, typeName string) bool {
+	switch typeName {
+	case "INTEGER":
+		n, err := strconv.ParseInt(field, 10, 64)
+		return err == nil && strconv.FormatInt(n, 10) == field
+	case "REAL":
+		f, ok := parseCSVFloat(field)
+		return ok && strconv.FormatFloat(f, 'f', -1, 64) == field
+	case "DATE", "DATETIME":
+		layout := csvDateLayout
+		if typeName == "DATETIME" {
+			layout = csvDateTimeLayout
 		}
-		// reset the string buffer - so it is empty ready for the next line to build
-		strbuffer.Reset()
-		// for debug - show the line number we are processing from the CSV file
-		// increment the line count - and loop back around for next line of the CSV file
-		lineCount += 1
-	}
-	// write out final line to the SQL file
-	bWritten, err := sqlFileBuffer.WriteString(strbuffer.String())
-	// check it wrote data ok - otherwise report the error giving the line number affected
-	if (err != nil) || (bWritten != len(strbuffer.Bytes())) {
-		return ""
-	}
-	strbuffer.WriteString("\nCOMMIT;")
-	// finished the SQl file data writing - flush any IO buffers
-	// NB below flush required as the data was being lost otherwise - maybe a bug in go version 1.2 only?
-	sqlFileBuffer.Flush()
-	// reset the string buffer - so it is empty as it is no longer needed
-	strbuffer.Reset()
-
-	return sqlOutFile
+		t, err := time.Parse(layout, field)
+		return err == nil && FormatCSVTime(t) == field
+	case "TEXT":
+		return true
+	}
+
+	if scale, ok := GetDecimalScale(typeName); ok {
+		f, ok := parseCSVFloat(field)
+		return ok && strings.IndexByte(field, '.') == len(field)-scale-1 &&
+			FormatCSVDecimal(strconv.FormatFloat(f, 'f', -1, 64), scale) == field
+	}
+
+	return true
+}
+
+// parseCSVFloat is a finite float. Negative zero doesn't count, sqlite drops its sign
+func parseCSVFloat(field string) (float64, bool) {
+	f, err := strconv.ParseFloat(field, 64)
+
+	return f, err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) && (f != 0 || !strings.HasPrefix(field, "-"))
+}
+
+// GetDecimalScale is the number of decimals of a DECIMAL(p,s) or NUMERIC(p,s) column, if it has any
+func GetDecimalScale(typeName string) (int, bool) {
+	match := decimalType.FindStringSubmatch(typeName)
+	if match == nil {
+		return 0, false
+	}
+	scale, err := strconv.Atoi(match[1])
+
+	return scale, err == nil && scale > 0
+}
+
+// FormatCSVDecimal pads the decimals of a number written by FormatFloat or FormatInt up to scale. Numbers with more
+// decimals than that keep them, nothing gets rounded
+func FormatCSVDecimal(number string, scale int) string {
+	decimals := 0
+	if i := strings.IndexByte(number, '.'); i > -1 {
+		decimals = len(number) - i - 1
+	} else {
+		number += "."
+	}
+	if decimals < scale {
+		number += strings.Repeat("0", scale-decimals)
+	}
+
+	return number
+}
+
+// FormatCSVTime writes a date (or time) the way the csv importer reads it back. Midnight is just the date
+func FormatCSVTime(t time.Time) string {
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0 {
+		return t.Format(csvDateLayout)
+	} else if t.Location() != time.UTC {
+		return t.Format(csvDateTimeLayout + "-07:00")
+	}
+
+	return t.Format(csvDateTimeLayout)
+}
+
+func quoteIdentifier(name string) string {
+	return "\"" + strings.ReplaceAll(name, "\"", "\"\"") + "\""
 }
 
 func cleanHeader(headField string) string {
@@ -0,0 +1,255 @@
+package tuiutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	EncodingUTF8   = "utf-8"
+	EncodingLatin1 = "latin-1"
+)
+
+const utf8BOM = "\xef\xbb\xbf"
+
+// CSVOptions describe the flavour of a delimited text file
+type CSVOptions struct {
+	Delimiter rune   // 0 picks one by the file extension, see For
+	Quote     rune   // 0 turns quoting off
+	Comment   string // lines starting with this are skipped, empty for none
+	NoHeader  bool   // the first row is data, columns get generated names
+	Encoding  string
+	Skip      int // lines ignored before the header (or first row)
+}
+
+// CSVFormat is how the file being viewed was read, it gets written back the same way
+var CSVFormat = DefaultCSVOptions()
+
+func DefaultCSVOptions() CSVOptions {
+	return CSVOptions{
+		Quote:    '"',
+		Encoding: EncodingUTF8,
+	}
+}
+
+// DelimitedExtensions are the file types opened as csv, and their delimiter
+var DelimitedExtensions = map[string]rune{
+	".csv": ',',
+	".tsv": '\t',
+	".psv": '|',
+}
+
+// For fills in the delimiter of a file if none was given, based on its extension
+func (o CSVOptions) For(fileName string) CSVOptions {
+	if o.Delimiter == 0 {
+		o.Delimiter = ','
+		if d, ok := DelimitedExtensions[strings.ToLower(filepath.Ext(fileName))]; ok {
+			o.Delimiter = d
+		}
+	}
+
+	return o
+}
+
+// IsDelimitedFile is true for files that get imported rather than opened as a database
+func IsDelimitedFile(fileName string) bool {
+	_, ok := DelimitedExtensions[strings.ToLower(filepath.Ext(fileName))]
+
+	return ok
+}
+
+// ParseCSVCharacter turns a flag value into the single character it stands for. tab and \t are accepted
+// for tabs, and an empty value is 0
+func ParseCSVCharacter(s string) (rune, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return 0, nil
+	case "tab", "\\t":
+		return '\t', nil
+	}
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("%s is not a single character", s)
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+
+	return r, nil
+}
+
+// ParseCSVEncoding normalizes the name of one of the supported encodings
+func ParseCSVEncoding(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case "", "utf8", "utf-8":
+		return EncodingUTF8, nil
+	case "latin1", "latin-1", "iso-8859-1", "iso8859-1":
+		return EncodingLatin1, nil
+	}
+
+	return "", fmt.Errorf("unsupported encoding %s, expected utf-8 or latin-1", s)
+}
+
+// CSVReader reads records of a delimited file, keeping track of the line each one started on. Unlike
+// encoding/csv it takes any quote character, and quotes in the middle of an unquoted field are kept as is
+type CSVReader struct {
+	r       *bufio.Reader
+	options CSVOptions
+	line    int
+}
+
+func NewCSVReader(r io.Reader, options CSVOptions) *CSVReader {
+	return &CSVReader{
+		r:       bufio.NewReader(r),
+		options: options,
+	}
+}
+
+// readLine gets the next line without its line ending, decoded to utf-8
+func (c *CSVReader) readLine() (string, error) {
+	s, err := c.r.ReadString('\n')
+	if err == io.EOF && s != "" {
+		err = nil // last line without a newline
+	}
+	if err != nil {
+		return "", err
+	}
+	c.line++
+
+	if c.line == 1 {
+		s = strings.TrimPrefix(s, utf8BOM)
+	}
+	if c.options.Encoding == EncodingLatin1 {
+		s = decodeLatin1(s)
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r"), nil
+}
+
+// Skip throws away the next n lines as they are, quotes and all
+func (c *CSVReader) Skip(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := c.readLine(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read returns the next record and the line it started on. Blank and comment lines are skipped. Errors
+// other than io.EOF are *CSVImportError, and reading can go on after them
+func (c *CSVReader) Read() ([]string, int, error) {
+	var (
+		line string
+		err  error
+	)
+	for {
+		line, err = c.readLine()
+		if err != nil {
+			return nil, 0, err
+		}
+		if line == "" || (c.options.Comment != "" && strings.HasPrefix(line, c.options.Comment)) {
+			continue
+		}
+		break
+	}
+
+	var (
+		start   = c.line
+		record  []string
+		field   strings.Builder
+		quoted  bool // in a quoted field
+		closed  bool // a quoted field has ended, only a delimiter can follow
+		chars   = []rune(line)
+		options = c.options
+	)
+	for i := 0; ; i++ {
+		if i == len(chars) {
+			if !quoted {
+				break
+			}
+			next, err := c.readLine() // a quoted line break
+			if err == io.EOF {
+				return nil, start, &CSVImportError{Line: start, Err: errors.New("quoted field is never closed")}
+			} else if err != nil {
+				return nil, start, err
+			}
+			field.WriteRune('\n')
+			chars, i = []rune(next), -1
+			continue
+		}
+
+		ch := chars[i]
+		switch {
+		case quoted && ch == options.Quote:
+			if i+1 < len(chars) && chars[i+1] == options.Quote { // doubled quote
+				field.WriteRune(ch)
+				i++
+			} else {
+				quoted, closed = false, true
+			}
+		case quoted:
+			field.WriteRune(ch)
+		case ch == options.Delimiter:
+			record = append(record, field.String())
+			field.Reset()
+			closed = false
+		case closed:
+			return nil, start, &CSVImportError{
+				Line: c.line,
+				Err:  fmt.Errorf("unexpected %c after the closing %c of a field", ch, options.Quote),
+			}
+		case ch == options.Quote && options.Quote != 0 && field.Len() == 0:
+			quoted = true
+		default:
+			field.WriteRune(ch)
+		}
+	}
+
+	return append(record, field.String()), start, nil
+}
+
+// FormatCSVRecord is the line a record is written as, without the line ending. Fields that hold the
+// delimiter, a quote or a line break are quoted
+func FormatCSVRecord(record []string, options CSVOptions) string {
+	fields := make([]string, len(record))
+	for i, field := range record {
+		special := strings.ContainsRune(field, options.Delimiter) || strings.ContainsAny(field, "\r\n") ||
+			(options.Quote != 0 && strings.ContainsRune(field, options.Quote))
+		if special && options.Quote != 0 {
+			quote := string(options.Quote)
+			field = quote + strings.ReplaceAll(field, quote, quote+quote) + quote
+		}
+		fields[i] = field
+	}
+	line := strings.Join(fields, string(options.Delimiter))
+	if options.Encoding == EncodingLatin1 {
+		line = encodeLatin1(line)
+	}
+
+	return line
+}
+
+func decodeLatin1(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		b.WriteRune(rune(s[i])) // latin-1 bytes are the first 256 code points
+	}
+
+	return b.String()
+}
+
+func encodeLatin1(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+
+	return string(b)
+}
@@ -0,0 +1,269 @@
+package tuiutil
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the kinds of json value a column can hold
+const (
+	jsonNull = iota
+	jsonString
+	jsonInteger
+	jsonReal
+	jsonBool
+	jsonNested // objects and arrays, or a mix of kinds
+)
+
+// JSONColumnTypes are the declared types of imported columns. BOOLEAN and JSON columns are turned back into
+// booleans and json values on export
+var JSONColumnTypes = map[int]string{
+	jsonNull:    "TEXT",
+	jsonString:  "TEXT",
+	jsonInteger: "INTEGER",
+	jsonReal:    "REAL",
+	jsonBool:    "BOOLEAN",
+	jsonNested:  "JSON",
+}
+
+// JSONExtensions are the file types opened as json, and whether they hold one object per line
+var JSONExtensions = map[string]bool{
+	".json":   false,
+	".ndjson": true,
+	".jsonl":  true,
+}
+
+// IsJSONFile is true for json and ndjson files, which get imported rather than opened as a database
+func IsJSONFile(fileName string) bool {
+	_, ok := JSONExtensions[strings.ToLower(filepath.Ext(fileName))]
+
+	return ok
+}
+
+// IsNDJSONFile is true for files that get one object per line when written back
+func IsNDJSONFile(fileName string) bool {
+	return JSONExtensions[strings.ToLower(filepath.Ext(fileName))]
+}
+
+// ImportJSON reads a json array of objects, or a stream of objects (ndjson), into a new table. Every top level key
+// becomes a column, in the order they're first seen. Nested objects and arrays are stored as json text, and so is
+// every value of a column that mixes kinds of values
+func ImportJSON(db *sql.DB, jsonFileName, tableName string) error {
+	if jsonFileName == "" || tableName == "" {
+		return errors.New("no json file or table name to import")
+	}
+
+	// the first read finds the columns and their kinds, the second inserts the rows
+	var (
+		columns []string
+		kinds   = map[string]int{}
+	)
+	err := readJSONObjects(jsonFileName, func(keys []string, values []json.RawMessage) error {
+		for i, key := range keys {
+			kind, seen := kinds[key]
+			if !seen {
+				columns = append(columns, key)
+			}
+			kinds[key] = mergeJSONKinds(kind, jsonKind(values[i]))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return errors.New("the file has no objects with keys to import")
+	}
+
+	definitions := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	index := map[string]int{}
+	for i, c := range columns {
+		definitions[i] = quoteIdentifier(c) + " " + JSONColumnTypes[kinds[c]]
+		placeholders[i] = "?"
+		index[c] = i
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	quotedTable := quoteIdentifier(tableName)
+	if _, err = tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", quotedTable, strings.Join(definitions, ", "))); err != nil {
+		return err
+	}
+	statement, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s VALUES (%s)", quotedTable, strings.Join(placeholders, ", ")))
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	err = readJSONObjects(jsonFileName, func(keys []string, values []json.RawMessage) error {
+		row := make([]interface{}, len(columns)) // missing keys are NULL
+		for i, key := range keys {
+			row[index[key]] = jsonValue(values[i], kinds[key])
+		}
+		_, err := statement.Exec(row...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// readJSONObjects calls found with the keys and values of every object in the file, in order. Errors say
+// which line they happened on
+func readJSONObjects(fileName string, found func(keys []string, values []json.RawMessage) error) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	decoder.UseNumber()
+	fail := func(err error) error {
+		offset := decoder.InputOffset()
+		if syntaxError, ok := err.(*json.SyntaxError); ok {
+			offset = syntaxError.Offset
+		}
+		return fmt.Errorf("line %d: %v", jsonLineAt(fileName, offset), err)
+	}
+
+	array, first := false, true
+	for {
+		if array && !decoder.More() {
+			if _, err = decoder.Token(); err != nil { // the closing ]
+				return fail(err)
+			}
+			array = false
+			continue
+		}
+
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fail(err)
+		}
+		if first && token == json.Delim('[') {
+			array, first = true, false
+			continue
+		}
+		first = false
+		if token != json.Delim('{') {
+			return fail(fmt.Errorf("expected an object but found %v", token))
+		}
+
+		var (
+			keys   []string
+			values []json.RawMessage
+		)
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return fail(err)
+			}
+			var value json.RawMessage
+			if err = decoder.Decode(&value); err != nil {
+				return fail(err)
+			}
+			keys = append(keys, key.(string))
+			values = append(values, value)
+		}
+		if _, err = decoder.Token(); err != nil { // the closing }
+			return fail(err)
+		}
+		if err = found(keys, values); err != nil {
+			return fail(err)
+		}
+	}
+}
+
+// jsonLineAt is the line of a file that offset falls on
+func jsonLineAt(fileName string, offset int64) int {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	b := make([]byte, offset)
+	n, _ := io.ReadFull(file, b)
+
+	return bytes.Count(b[:n], []byte("\n")) + 1
+}
+
+func jsonKind(value json.RawMessage) int {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return jsonNull
+	}
+
+	switch value[0] {
+	case 'n':
+		return jsonNull
+	case '"':
+		return jsonString
+	case 't', 'f':
+		return jsonBool
+	case '{', '[':
+		return jsonNested
+	}
+	if bytes.ContainsAny(value, ".eE") {
+		return jsonReal
+	}
+
+	return jsonInteger
+}
+
+// mergeJSONKinds is the kind of a column holding values of both kinds
+func mergeJSONKinds(a, b int) int {
+	switch {
+	case a == b || b == jsonNull:
+		return a
+	case a == jsonNull:
+		return b
+	case (a == jsonInteger && b == jsonReal) || (a == jsonReal && b == jsonInteger):
+		return jsonReal
+	}
+
+	return jsonNested
+}
+
+// jsonValue is what gets stored for a value in a column of kind
+func jsonValue(value json.RawMessage, kind int) interface{} {
+	if jsonKind(value) == jsonNull {
+		return nil
+	}
+
+	switch kind {
+	case jsonString:
+		var s string
+		json.Unmarshal(value, &s)
+		return s
+	case jsonBool:
+		return bytes.Equal(bytes.TrimSpace(value), []byte("true"))
+	case jsonInteger, jsonReal:
+		return string(value) // the column affinity takes care of numbers
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, value); err != nil {
+		return string(value)
+	}
+
+	return compact.String()
+}
@@ -153,10 +153,13 @@ type TextInputModel struct {
 	cursorMode CursorMode
 }
 
+// DefaultPrompt is shown in front of the text of a new model
+const DefaultPrompt = "> "
+
 // NewModel creates a new model with default settings.
 func NewModel() TextInputModel {
 	m := TextInputModel{
-		Prompt:           "> ",
+		Prompt:           DefaultPrompt,
 		BlinkSpeed:       DefaultBlinkSpeed,
 		EchoCharacter:    '*',
 		CharLimit:        0,
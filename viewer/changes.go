@@ -0,0 +1,406 @@
+package viewer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mathaou/termdbms/database"
+	"github.com/mathaou/termdbms/list"
+)
+
+// ChangesState is what differs between the working copy and the file it was made from, or the file given to :diff
+type ChangesState struct {
+	Compare  string // the file compared with by :diff, empty for the pending changes
+	Restored bool   // the working copy came from a crashed session, its changes aren't in the undo journal
+	List     []database.Change
+	Cells    map[string]bool     // cells changed by the steps in the undo journal, see changedCellKey
+	Keys     map[string][]string // the columns rows of each changed table are matched by
+	Saved    int64               // the last journal entry when the changes were written over the original file
+	Panel    list.Model
+}
+
+// ChangeItem is a pending change in the changes panel
+type ChangeItem struct {
+	database.Change
+}
+
+func (c ChangeItem) Title() string {
+	if c.Kind == database.ChangeSchema {
+		return fmt.Sprintf("%s %s %s", c.GetSchemaChangeVerb(), c.ObjectType, c.Object)
+	}
+	if c.Kind == database.ChangeUpdate {
+		return fmt.Sprintf("update %s (%s) %s", c.Table, GetChangeKeyString(c.Change), c.Column)
+	}
+
+	return fmt.Sprintf("%s %s (%s)", c.Kind, c.Table, GetChangeKeyString(c.Change))
+}
+
+func (c ChangeItem) Description() string {
+	switch c.Kind {
+	case database.ChangeSchema:
+		return GetSchemaChangeString(c.Change)
+	case database.ChangeUpdate:
+		return fmt.Sprintf("%s -> %s", GetChangeValueString(c.Old), GetChangeValueString(c.New))
+	}
+
+	return GetChangeRowString(c.Change)
+}
+
+func (c ChangeItem) FilterValue() string {
+	return c.Title()
+}
+
+// GetChangeKeyString is key=value for each column the changed row is matched by
+func GetChangeKeyString(c database.Change) string {
+	var pairs []string
+	for i, k := range c.KeyColumns {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, GetChangeValueString(c.Key[i])))
+	}
+
+	return strings.Join(pairs, ", ")
+}
+
+// GetChangeRowString is every column=value of an inserted or deleted row
+func GetChangeRowString(c database.Change) string {
+	var pairs []string
+	for i, column := range c.Columns {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", column, GetChangeValueString(c.Row[i])))
+	}
+
+	return strings.Join(pairs, ", ")
+}
+
+// GetSchemaChangeString is the new CREATE statement of an object on one line, or the old one if it was dropped
+func GetSchemaChangeString(c database.Change) string {
+	statement := c.Statement
+	if statement == "" {
+		statement = c.OldStatement
+	}
+
+	return strings.Join(strings.Fields(statement), " ")
+}
+
+// getChangeRowLine is the values of an inserted or deleted row, after its key if that isn't one of the columns
+func getChangeRowLine(c database.Change) string {
+	for _, k := range c.KeyColumns {
+		if indexOf(c.Columns, k) < 0 {
+			return fmt.Sprintf("%s: %s", GetChangeKeyString(c), GetChangeRowString(c))
+		}
+	}
+
+	return GetChangeRowString(c)
+}
+
+// GetChangeValueString shows NULL apart from an empty string, and numbers in full so no change looks like a no-op
+func GetChangeValueString(value interface{}) string {
+	if value == nil {
+		return "NULL"
+	}
+
+	return GetCSVRepresentationOfInterface(value)
+}
+
+// changedCellKey identifies a cell of a table by the quoted key of its row. An empty column is the whole row
+func changedCellKey(table, key, column string) string {
+	return strings.Join([]string{table, key, column}, "\x00")
+}
+
+// GetComparedFileName is the file changes are against, the one given to :diff or else the one the working copy
+// was made from
+func (m *TuiModel) GetComparedFileName() string {
+	if m.Changes.Compare != "" {
+		return m.Changes.Compare
+	}
+
+	return m.BaseFileName
+}
+
+// RefreshChanges compares the working copy with the file it was made from again, or with the file given to
+// :diff. Pending changes only need the tables in the undo journal compared, :diff compares them all. Only
+// SQLite files have a working copy, changes to server databases are made as they're written. Comparing reads
+// whole tables, so it's only done for the changes panel and what gets written from it
+func (m *TuiModel) RefreshChanges() error {
+	m.Changes.List = nil
+	if _, ok := m.DefaultTable.Database.(*database.SQLite); !ok || m.GetComparedFileName() == "" {
+		return nil
+	}
+
+	var (
+		tables map[string]bool
+		err    error
+	)
+	if m.Changes.Compare == "" && m.Journal != nil && !m.Changes.Restored {
+		if tables, err = m.Journal.GetChangedTables(); err != nil {
+			return err
+		}
+	}
+	m.Changes.List, err = database.DiffSQLite(m.DefaultTable.Database.GetDatabaseReference(), m.GetComparedFileName(), tables)
+
+	return err
+}
+
+// UpdateChangedCells reads the cells to highlight from the undo journal after an edit, undo or redo. Nothing gets
+// compared, so it costs as much as the journal is long rather than the tables. Changes restored from a crashed
+// session aren't in the journal, they're only in the changes panel. Failing is only worth a message, the edit
+// itself went through
+func (m *TuiModel) UpdateChangedCells() {
+	m.Changes.Cells = map[string]bool{}
+	m.Changes.Keys = map[string][]string{}
+	if m.Journal == nil {
+		return
+	}
+
+	cells, err := m.Journal.GetChangedCells(m.Changes.Saved)
+	if err != nil {
+		m.WriteMessage(fmt.Sprintf("Could not read the changed cells: %v", err))
+		return
+	}
+	for _, c := range cells {
+		if _, ok := m.Changes.Keys[c.Table]; !ok {
+			key := m.DefaultTable.Database.GetTableKey(c.Table)
+			m.Changes.Keys[c.Table] = key.Columns
+			if key.RowID != "" {
+				m.Changes.Keys[c.Table] = []string{key.RowID}
+			}
+		}
+		m.Changes.Cells[changedCellKey(c.Table, c.Key, c.Column)] = true
+	}
+}
+
+// IsChangedCell is true if the cell at row (of the loaded page) and column differs from the original file
+func (m *TuiModel) IsChangedCell(row int, column string) bool {
+	if len(m.Changes.Cells) == 0 || m.QueryResult != nil {
+		return false
+	}
+	table := m.GetSchemaName()
+	keys, ok := m.Changes.Keys[table]
+	if !ok {
+		return false
+	}
+
+	data := m.GetSchemaData()
+	key := make([]interface{}, len(keys))
+	for i, k := range keys {
+		if k == "rowid" {
+			k = database.RowIDColumn
+		}
+		values, ok := data[k]
+		if !ok || row < 0 || row >= len(values) {
+			return false
+		}
+		key[i] = values[row]
+	}
+
+	quoted := database.QuoteKey(key)
+
+	return m.Changes.Cells[changedCellKey(table, quoted, column)] || m.Changes.Cells[changedCellKey(table, quoted, "")]
+}
+
+// OpenChangesPanel lists every change that hasn't been saved to the original file yet, or every difference
+// from the file given to :diff
+func OpenChangesPanel(m *TuiModel) error {
+	if _, ok := m.DefaultTable.Database.(*database.SQLite); !ok {
+		m.WriteMessage(fmt.Sprintf("Changes to %s databases are written as they're made.", database.DriverString))
+		return nil
+	} else if m.GetComparedFileName() == "" {
+		m.WriteMessage("Nothing can be changed in read-only mode, use :diff <PATH> to compare with another file.")
+		return nil
+	}
+	if err := m.RefreshChanges(); err != nil {
+		return err
+	}
+	if len(m.Changes.List) == 0 && m.Changes.Compare != "" {
+		m.WriteMessage(fmt.Sprintf("No differences from %s.", m.Changes.Compare))
+		return nil
+	} else if len(m.Changes.List) == 0 {
+		m.WriteMessage("No pending changes.")
+		return nil
+	}
+
+	items := make([]list.Item, len(m.Changes.List))
+	for i, c := range m.Changes.List {
+		items[i] = ChangeItem{c}
+	}
+
+	index := m.Changes.Panel.Index()
+	m.Changes.Panel = list.NewModel(items, list.NewDefaultDelegate(), TUIWidth, TUIHeight)
+	m.Changes.Panel.Title = fmt.Sprintf("%d pending change(s) to %s", len(items), m.InitialFileName)
+	if m.Changes.Compare != "" {
+		m.Changes.Panel.Title = fmt.Sprintf("%d difference(s) from %s", len(items), m.Changes.Compare)
+	}
+	if database.ReadOnly { // only :diff opens the panel
+		m.Changes.Panel.Title += " - [W] write report, [S] write SQL patch"
+	} else {
+		m.Changes.Panel.Title += " - [ENTER] revert, [W] write report, [S] write SQL patch"
+		m.Changes.Panel.KeyMap.DeleteSelection.SetHelp("r", "revert") // reverting takes the place of removing
+	}
+	m.Changes.Panel.SetFilteringEnabled(true)
+	m.Changes.Panel.SetShowPagination(true)
+	m.Changes.Panel.SetShowTitle(true)
+	m.Changes.Panel.Select(Min(index, len(items)-1))
+	m.UI.ShowChanges = true
+
+	return nil
+}
+
+func ShowChanges(m *TuiModel) string {
+	return m.Changes.Panel.View()
+}
+
+func HandleChangesEvents(m *TuiModel, str string, command *tea.Cmd, msg tea.Msg) {
+	if m.Changes.Panel.FilterState() == list.Filtering {
+		m.Changes.Panel, *command = m.Changes.Panel.Update(msg)
+		return
+	}
+
+	switch str {
+	case "q", "esc":
+		closeChanges(m)
+	case "enter", "r":
+		item, ok := m.Changes.Panel.SelectedItem().(ChangeItem)
+		if !ok || database.ReadOnly {
+			return
+		}
+		err := m.RecordEdit(func() error {
+			return database.RevertChange(m.DefaultTable.Database.GetDatabaseReference(), m.GetComparedFileName(), item.Change)
+		})
+		if err != nil {
+			m.UI.ShowChanges = false
+			m.DisplayMessage(fmt.Sprintf("%v", err))
+			return
+		}
+		m.InvalidatePage() // rows get read back from the database
+		m.DefaultTable.RowCounts = make(map[string]int)
+		m.UI.ShowChanges = false
+		if err = OpenChangesPanel(m); err != nil {
+			m.DisplayMessage(fmt.Sprintf("%v", err))
+		}
+		if !m.UI.ShowChanges { // nothing left to revert
+			closeChanges(m)
+		}
+	case "w":
+		count := len(m.Changes.List)
+		fileName, err := m.WriteChangeReport()
+		closeChanges(m)
+		if err != nil {
+			m.DisplayMessage(fmt.Sprintf("%v", err))
+		} else {
+			m.WriteMessage(fmt.Sprintf("Wrote %d change(s) to %s.", count, fileName))
+		}
+	case "s":
+		count := len(m.Changes.List)
+		fileName, err := m.WriteChangePatch()
+		closeChanges(m)
+		if err != nil {
+			m.DisplayMessage(fmt.Sprintf("%v", err))
+		} else {
+			m.WriteMessage(fmt.Sprintf("Wrote a SQL script with %d change(s) to %s.", count, fileName))
+		}
+	default:
+		m.Changes.Panel, *command = m.Changes.Panel.Update(msg)
+	}
+}
+
+// closeChanges goes back to the table, and to the pending changes after a comparison
+func closeChanges(m *TuiModel) {
+	m.UI.ShowChanges = false
+	m.Changes.Panel.ResetFilter()
+	m.Changes.Compare = ""
+}
+
+// WriteChangeReport writes the changes to name-changes.txt next to where termdbms was started
+func (m *TuiModel) WriteChangeReport() (string, error) {
+	from := m.InitialFileName
+	if m.Changes.Compare != "" {
+		from = m.Changes.Compare
+	}
+
+	return m.writeChangeFile("changes", "txt", func(w io.Writer) error {
+		return WriteChanges(w, from, "working copy", m.Changes.List)
+	})
+}
+
+// WriteChangePatch writes a SQL script that makes the changes, to name-patch.sql next to where termdbms was started
+func (m *TuiModel) WriteChangePatch() (string, error) {
+	return m.writeChangeFile("patch", "sql", func(w io.Writer) error {
+		return database.WriteSQLitePatch(w, m.DefaultTable.Database.GetDatabaseReference(), m.Changes.List)
+	})
+}
+
+func (m *TuiModel) writeChangeFile(suffix, extension string, write func(w io.Writer) error) (string, error) {
+	base := filepath.Base(m.InitialFileName)
+	fileName := getFreeFileName(strings.TrimSuffix(base, filepath.Ext(base))+"-"+suffix, extension)
+	f, err := os.Create(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err = write(f); err != nil {
+		return "", err
+	}
+
+	return fileName, f.Close()
+}
+
+// handleDiff compares the working copy in full with path, or with the file it was opened from if that's empty
+func handleDiff(m *TuiModel, path string) {
+	ExitToDefaultView(m)
+	if path == "" {
+		path = m.BaseFileName
+	}
+	if path == "" {
+		m.WriteMessage("There's no original file to compare with, use :diff <PATH>.")
+		return
+	} else if exists, _ := Exists(path); !exists {
+		m.DisplayMessage(fmt.Sprintf("%s does not exist.", path))
+		return
+	}
+
+	m.Changes.Compare = path
+	if err := OpenChangesPanel(m); err != nil {
+		m.DisplayMessage(fmt.Sprintf("%v", err))
+	}
+	if !m.UI.ShowChanges {
+		m.Changes.Compare = ""
+	}
+}
+
+// WriteChanges writes a readable report of changes from one database to another, grouped by table.
+// Updated cells are marked ~, inserted rows +, deleted rows - and schema changes !
+func WriteChanges(w io.Writer, from, to string, changes []database.Change) error {
+	if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", from, to); err != nil {
+		return err
+	}
+
+	table := ""
+	for _, c := range changes {
+		if c.Table != table {
+			table = c.Table
+			if _, err := fmt.Fprintf(w, "\n%s\n", table); err != nil {
+				return err
+			}
+		}
+		var line string
+		switch c.Kind {
+		case database.ChangeSchema:
+			line = fmt.Sprintf("! %s %s %s: %s", c.GetSchemaChangeVerb(), c.ObjectType, c.Object, GetSchemaChangeString(c))
+		case database.ChangeUpdate:
+			line = fmt.Sprintf("~ %s %s: %s -> %s", GetChangeKeyString(c), c.Column,
+				GetChangeValueString(c.Old), GetChangeValueString(c.New))
+		case database.ChangeInsert:
+			line = "+ " + getChangeRowLine(c)
+		case database.ChangeDelete:
+			line = "- " + getChangeRowLine(c)
+		}
+		if _, err := fmt.Fprintf(w, "  %s\n", line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package viewer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mathaou/termdbms/database"
+	"github.com/mathaou/termdbms/tuiutil"
+)
+
+// DestructiveStatements are the SQL mode statements that get confirmed before they run
+var DestructiveStatements = map[string]bool{
+	"delete": true,
+	"update": true,
+	"drop":   true,
+	"alter":  true,
+}
+
+// QueryStatements are the SQL mode statements that can run in read-only mode
+var QueryStatements = map[string]bool{
+	"select":   true,
+	"with":     true,
+	"values":   true,
+	"explain":  true,
+	"pragma":   true,
+	"show":     true,
+	"describe": true,
+}
+
+func getQueryStatementList() string {
+	var keywords []string
+	for k := range QueryStatements {
+		keywords = append(keywords, strings.ToUpper(k))
+	}
+	sort.Strings(keywords)
+
+	return strings.Join(keywords, ", ")
+}
+
+// Confirmation is a question shown over the table until it's answered. A yes/no question runs Action on yes,
+// a question with Choices runs the action of the chosen one
+type Confirmation struct {
+	Message string
+	Action  func(m *TuiModel)
+	Choices []Choice
+}
+
+// Choice is one answer to a question that isn't yes or no. Key is what's pressed for it
+type Choice struct {
+	Key    string
+	Label  string
+	Action func(m *TuiModel)
+}
+
+// Confirm asks the user before running action. Everything else waits for the answer
+func (m *TuiModel) Confirm(message string, action func(m *TuiModel)) {
+	m.Confirmation = &Confirmation{
+		Message: message,
+		Action:  action,
+	}
+}
+
+// Ask is Confirm with answers of its own. Without an esc choice esc cancels like no does
+func (m *TuiModel) Ask(message string, choices ...Choice) {
+	m.Confirmation = &Confirmation{
+		Message: message,
+		Choices: choices,
+	}
+}
+
+// HandleConfirmationEvents answers the confirmation, y or enter for yes, n, q or esc for no
+func HandleConfirmationEvents(m *TuiModel, str string) {
+	c := m.Confirmation
+	str = strings.ToLower(str)
+	if len(c.Choices) > 0 {
+		for _, choice := range c.Choices {
+			if choice.Key == str {
+				m.Confirmation = nil
+				choice.Action(m)
+				return
+			}
+		}
+		if str == "esc" {
+			m.Confirmation = nil
+			m.WriteMessage("Cancelled.")
+		}
+		return
+	}
+
+	switch str {
+	case "y", "enter":
+		m.Confirmation = nil
+		c.Action(m)
+	case "n", "q", "esc":
+		m.Confirmation = nil
+		m.WriteMessage("Cancelled.")
+	}
+}
+
+// DisplayConfirmation draws the question in a box in the middle of the view
+func DisplayConfirmation(m *TuiModel) string {
+	width := Min(m.Viewport.Width-4, 72)
+	style := lipgloss.NewStyle().
+		Width(width).
+		Padding(1, 2).
+		Border(lipgloss.RoundedBorder())
+	if !tuiutil.Ascii {
+		style = style.
+			Foreground(lipgloss.Color(tuiutil.TextColor())).
+			BorderForeground(lipgloss.Color(tuiutil.Highlight()))
+	}
+
+	answers := "[Y]es    [N]o"
+	if len(m.Confirmation.Choices) > 0 {
+		var labels []string
+		for _, choice := range m.Confirmation.Choices {
+			labels = append(labels, fmt.Sprintf("[%s] %s", strings.ToUpper(choice.Key), choice.Label))
+		}
+		answers = strings.Join(labels, "    ")
+	}
+	box := style.Render(fmt.Sprintf("%s\n\n%s", m.Confirmation.Message, answers))
+
+	return lipgloss.Place(m.Viewport.Width, m.Viewport.Height, lipgloss.Center, lipgloss.Center, box)
+}
+
+// GetStatementKeyword is the first word of a statement, lowercased
+func GetStatementKeyword(statement string) string {
+	fields := strings.Fields(statement)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return strings.ToLower(fields[0])
+}
+
+// CanTryStatement is true when a destructive statement can be tried in a transaction that's rolled back, to count
+// the rows it changes and catch errors early. MySQL commits schema changes right away and MyISAM or MEMORY tables
+// ignore the rollback, there's no trying anything there
+func CanTryStatement(db database.Database) bool {
+	_, isMySQL := db.(*database.MySQL)
+
+	return !isMySQL
+}
+
+// getStatementConfirmation is the question asked before running a destructive statement. count is the number of
+// rows the statement changed when it was tried, -1 if it wasn't
+func getStatementConfirmation(m *TuiModel, statement string, count int64) string {
+	keyword := GetStatementKeyword(statement)
+	preview := statement
+	if lines := SplitLines(strings.TrimSpace(statement)); len(lines) > 6 {
+		preview = strings.Join(append(lines[:6], "..."), "\n")
+	}
+	question := fmt.Sprintf("Run this %s statement?\n\n%s\n\n", strings.ToUpper(keyword), strings.TrimSpace(preview))
+
+	if keyword == "drop" || keyword == "alter" {
+		return question + "Schema changes can't be undone."
+	}
+
+	if count < 0 {
+		question += "The number of rows it will change is unknown."
+	} else {
+		question += fmt.Sprintf("It will change %d row(s).", count)
+	}
+	if m.Journal != nil {
+		question += " It can be undone with [U]."
+	}
+
+	return question
+}
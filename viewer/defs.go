@@ -21,8 +21,19 @@ type ScrollData struct {
 
 // TableState holds everything needed to save/serialize state
 type TableState struct {
-	Database database.Database
-	Data     map[string]interface{}
+	Database   database.Database
+	Data       map[string]interface{}
+	PageOffset int                  // absolute row of the first cached row, tables are loaded a page at a time
+	RowCounts  map[string]int       // total rows per table, -1 while the background count is running
+	Views      map[string]TableView // sort and filter per table, kept when the model is reloaded
+}
+
+// TableView is the sort and filter applied to a table
+type TableView struct {
+	SortColumn     string
+	SortDescending bool
+	Filter         string // where condition
+	FilterText     string // what the user typed, for the header
 }
 
 type UIState struct {
@@ -32,7 +43,15 @@ type UIState struct {
 	FormatModeEnabled bool
 	BorderToggle      bool
 	SQLEdit           bool
+	InsertForm        bool // format mode is holding the new row form
+	SearchPrompt      bool // edit mode is taking a search term
+	SearchAll         bool // the search term is for every table, not just the current one
+	ShowSearchResults bool
+	ShowSchema        bool
+	SchemaDetail      bool // the full screen view is showing an object from the schema browser
 	ShowClipboard     bool
+	ShowChanges       bool // the pending changes panel
+	ShowHistory       bool // the SQL history browser
 	ExpandColumn      int
 	CurrentTable      int
 }
@@ -41,10 +60,17 @@ type UIData struct {
 	TableHeaders      map[string][]string // keeps track of which schema has which headers
 	TableHeadersSlice []string
 	TableSlices       map[string][]interface{}
-	TableIndexMap     map[int]string // keeps the schemas in order
+	TableIndexMap     map[int]string  // keeps the schemas in order
+	ReadOnly          map[string]bool // views, which are shown like tables but can't be edited
 	EditTextBuffer    string
 }
 
+// SearchState is the last thing searched for
+type SearchState struct {
+	Term    string     // cells of the current table containing this are highlighted
+	Results list.Model // hits of the last search through every table
+}
+
 type FormatState struct {
 	EditSlices     []*string // the bit to show
 	Text           []string  // the master collection of lines to edit
@@ -64,6 +90,7 @@ type TuiModel struct {
 	Scroll          ScrollData
 	Ready           bool
 	InitialFileName string // used if saving destructively
+	BaseFileName    string // the file the working copy was made from, pending changes are what differs from it
 	Viewport        viewport.Model
 	ClipboardList   list.Model
 	Clipboard       []list.Item
@@ -71,6 +98,12 @@ type TuiModel struct {
 	MouseData       tea.MouseEvent
 	TextInput       LineEdit
 	FormatInput     LineEdit
-	UndoStack       []TableState
-	RedoStack       []TableState
+	Journal         *database.Journal // undo/redo history, SQLite only
+	Search          SearchState
+	SchemaList      list.Model
+	Confirmation    *Confirmation // a question that has to be answered before anything else happens
+	Changes         ChangesState
+	Recovery        []CrashedSession // crashed sessions of the same files, offered for recovery one at a time
+	Query           *QueryState      // the SQL mode statement running in the background
+	History         HistoryState
 }
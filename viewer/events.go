@@ -1,9 +1,6 @@
 package viewer
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
 	"time"
 
 	"github.com/charmbracelet/bubbles/viewport"
@@ -27,7 +24,11 @@ func HandleMouseEvents(m *TuiModel, msg *tea.MouseMsg) {
 		}
 		break
 	case tea.MouseLeft:
-		if !m.UI.EditModeEnabled && !m.UI.FormatModeEnabled && m.GetRow() < len(m.GetColumnData()) {
+		if msg.Y == HeaderHeight-1 && !m.UI.RenderSelection && !m.UI.EditModeEnabled && !m.UI.FormatModeEnabled { // column headers
+			m.CycleSort(m.GetHeaderAt(msg.X))
+			break
+		}
+		if !m.UI.EditModeEnabled && !m.UI.FormatModeEnabled && m.GetRow()+m.Viewport.YOffset < m.GetRowCount() {
 			SelectOption(m)
 		}
 		break
@@ -100,11 +101,7 @@ func HandleClipboardEvents(m *TuiModel, str string, command *tea.Cmd, msg tea.Ms
 		m.ClipboardList, *command = m.ClipboardList.Update(msg)
 		if len(m.ClipboardList.Items()) != tmpItems { // if item removed
 			m.Clipboard = m.ClipboardList.Items()
-			b, _ := json.Marshal(m.Clipboard)
-			snippetsFile := fmt.Sprintf("%s/%s", HiddenTmpDirectoryName, SQLSnippetsFile)
-			f, _ := os.OpenFile(snippetsFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0775)
-			f.Write(b)
-			f.Close()
+			WriteSnippets(m.Clipboard)
 		}
 	}
 }
@@ -118,6 +115,7 @@ func HandleKeyboardEvents(m *TuiModel, msg *tea.KeyMsg) tea.Cmd {
 
 	if m.UI.EditModeEnabled { // handle edit mode
 		HandleEditMode(m, str)
+		m.UpdateSearchTerm()
 		return nil
 	} else if m.UI.FormatModeEnabled {
 		if str == "esc" { // cycle focus
@@ -131,6 +129,10 @@ func HandleKeyboardEvents(m *TuiModel, msg *tea.KeyMsg) tea.Cmd {
 			return cmd
 		}
 
+		if m.UI.SQLEdit && (str == "up" || str == "down") && RecallHistory(m, str == "up") {
+			return nil
+		}
+
 		if m.TextInput.Model.Focused() {
 			HandleEditMode(m, str)
 		} else {
@@ -0,0 +1,515 @@
+package viewer
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mathaou/termdbms/database"
+)
+
+const (
+	ExportCSV      = "csv"
+	ExportJSON     = "json"
+	ExportNDJSON   = "ndjson"
+	ExportMarkdown = "markdown"
+	ExportHTML     = "html"
+	ExportSQL      = "sql"
+)
+
+// ExportColumn is a column of the rows being exported. TypeName is its declared type, if the driver knows it
+type ExportColumn struct {
+	Name     string
+	TypeName string
+}
+
+// Exporter writes rows in some format. Begin is called once before the rows and End once after them
+type Exporter interface {
+	Begin(w io.Writer, db database.Database, table string, columns []ExportColumn) error
+	WriteRow(values []interface{}) error
+	End() error
+}
+
+// ExportFormat is a format that :export can write. Add one to ExportFormats to make it available
+type ExportFormat struct {
+	Extension string
+	New       func() Exporter
+}
+
+var ExportFormats = map[string]ExportFormat{
+	ExportCSV:      {"csv", func() Exporter { return &csvExporter{} }},
+	ExportJSON:     {"json", func() Exporter { return &jsonExporter{} }},
+	ExportNDJSON:   {"ndjson", func() Exporter { return &jsonExporter{ndjson: true} }},
+	ExportMarkdown: {"md", func() Exporter { return &markdownExporter{} }},
+	ExportHTML:     {"html", func() Exporter { return &htmlExporter{} }},
+	ExportSQL:      {"sql", func() Exporter { return &sqlExporter{} }},
+}
+
+// ExportAliases are other names the formats go by
+var ExportAliases = map[string]string{
+	"md":     ExportMarkdown,
+	"jsonl":  ExportNDJSON,
+	"htm":    ExportHTML,
+	"insert": ExportSQL,
+}
+
+var exportRange = regexp.MustCompile(`^(\d+)(-(\d*))?$`)
+
+// GetExportFormat looks up a format by name or alias
+func GetExportFormat(name string) (ExportFormat, bool) {
+	name = strings.ToLower(name)
+	if alias, ok := ExportAliases[name]; ok {
+		name = alias
+	}
+	f, ok := ExportFormats[name]
+
+	return f, ok
+}
+
+// GetExportFormatNames lists the formats for error messages and help
+func GetExportFormatNames() string {
+	var names []string
+	for name := range ExportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// ParseExportCommand reads the arguments of :export <format> [path] [rows]. rows is a range of row numbers like
+// 5-10 or 5- (to the end), or a single row, counted from 1 the way the table is shown. from and to are returned counted from 0,
+// with to -1 for every row
+func ParseExportCommand(args string) (format, fileName string, from, to int, err error) {
+	fields := strings.Fields(args)
+	to = -1
+	if len(fields) == 0 {
+		return "", "", 0, 0, fmt.Errorf("usage: :export <format> [path] [rows], formats are %s", GetExportFormatNames())
+	}
+	format, fields = fields[0], fields[1:]
+
+	if n := len(fields); n > 0 {
+		if match := exportRange.FindStringSubmatch(fields[n-1]); match != nil {
+			first, _ := strconv.Atoi(match[1])
+			from, to = Max(first-1, 0), first
+			if match[2] != "" { // a range, not a single row
+				to = -1
+				if match[3] != "" {
+					last, _ := strconv.Atoi(match[3])
+					to = last
+				}
+			}
+			if to >= 0 && to <= from {
+				return "", "", 0, 0, fmt.Errorf("%s is not a range of rows", fields[n-1])
+			}
+			fields = fields[:n-1]
+		}
+	}
+	if len(fields) > 1 {
+		return "", "", 0, 0, errors.New("usage: :export <format> [path] [rows], paths can't have spaces")
+	}
+	if len(fields) == 1 {
+		fileName = fields[0]
+	}
+
+	return format, fileName, from, to, nil
+}
+
+func handleExport(m *TuiModel, args string) {
+	fields := strings.Fields(args)
+	if n := len(fields); n > 1 && fields[n-1] == "." { // just the selected row
+		row, _ := m.GetSelectedCell()
+		fields[n-1] = strconv.Itoa(row + 1)
+	}
+
+	ExitToDefaultView(m)
+	format, fileName, from, to, err := ParseExportCommand(strings.Join(fields, " "))
+	if err == nil {
+		fileName, err = m.Export(format, fileName, from, to)
+	}
+	if err != nil {
+		m.DisplayMessage(fmt.Sprintf("%v", err))
+		return
+	}
+	m.WriteMessage(fmt.Sprintf("Exported to %s.", fileName))
+}
+
+// Export writes rows [from, to) of the current table, or the query results being shown, to fileName. The table is
+// exported with its sort and filter. Without a fileName one is made up from the table name that doesn't clobber
+// anything. The name of the file written is returned
+func (m *TuiModel) Export(format, fileName string, from, to int) (string, error) {
+	f, ok := GetExportFormat(format)
+	if !ok {
+		return "", fmt.Errorf("unknown export format %s, expected one of %s", format, GetExportFormatNames())
+	}
+
+	name := m.GetSchemaName()
+	if m.QueryData != nil {
+		name = QueryResultsTableName
+	}
+	if fileName == "" {
+		fileName = getFreeFileName(strings.ReplaceAll(name, ".", "_"), f.Extension)
+	}
+
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	if m.QueryData != nil { // results are all in memory already
+		err = ExportData(w, f.New(), m.Table().Database, name, m.QueryData.TableHeaders[QueryResultsTableName],
+			m.GetSchemaData(), from, to)
+	} else {
+		t := &m.DefaultTable
+		limit := -1
+		if to >= 0 {
+			limit = to - from
+		}
+		var c *sql.Rows
+		c, err = t.Database.GetDatabaseReference().Query(GetPageQuery(t.Database, name, m.GetTableView(), from, limit))
+		if err != nil {
+			return "", err
+		}
+		err = ExportRows(w, f.New(), t.Database, name, c)
+		c.Close()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return fileName, w.Flush()
+}
+
+// WriteTableToFile writes every row of a table to fileName with e, in key order
+func WriteTableToFile(db database.Database, tableName, fileName string, e Exporter) error {
+	c, err := db.GetDatabaseReference().Query(GetPageQuery(db, tableName, TableView{}, 0, -1))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return ReplaceFile(fileName, func(w *bufio.Writer) error {
+		return ExportRows(w, e, db, tableName, c)
+	})
+}
+
+// ExportRows writes every row of c with e. The row id kept alongside keyless tables is left out
+func ExportRows(w io.Writer, e Exporter, db database.Database, table string, c *sql.Rows) error {
+	names, err := c.Columns()
+	if err != nil {
+		return err
+	}
+	types, _ := c.ColumnTypes()
+
+	var columns []ExportColumn
+	keep := make([]bool, len(names))
+	for i, name := range names {
+		if name == database.RowIDColumn {
+			continue
+		}
+		keep[i] = true
+		column := ExportColumn{Name: name}
+		if types != nil {
+			column.TypeName = strings.ToUpper(types[i].DatabaseTypeName())
+		}
+		columns = append(columns, column)
+	}
+	if err = e.Begin(w, db, table, columns); err != nil {
+		return err
+	}
+
+	_, mysql := db.(*database.MySQL)
+	values := make([]interface{}, len(names))
+	pointers := make([]interface{}, len(names))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for c.Next() {
+		if err = c.Scan(pointers...); err != nil {
+			return err
+		}
+		var row []interface{}
+		for i, v := range values {
+			if !keep[i] {
+				continue
+			}
+			if b, ok := v.([]byte); ok && mysql && !isBinaryType(columns[len(row)].TypeName) { // mysql hands back text as bytes
+				v = string(b)
+			}
+			row = append(row, v)
+		}
+		if err = e.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	if err = c.Err(); err != nil {
+		return err
+	}
+
+	return e.End()
+}
+
+// ExportData writes rows [from, to) of data that has been read into memory already, like query results
+func ExportData(w io.Writer, e Exporter, db database.Database, table string, headers []string, data map[string][]interface{}, from, to int) error {
+	columns := make([]ExportColumn, len(headers))
+	for i, h := range headers {
+		columns[i] = ExportColumn{Name: h}
+	}
+	if err := e.Begin(w, db, table, columns); err != nil {
+		return err
+	}
+
+	rows := pageLength(data)
+	if to < 0 || to > rows {
+		to = rows
+	}
+	for r := from; r < to; r++ {
+		row := make([]interface{}, len(headers))
+		for i, h := range headers {
+			row[i] = data[h][r]
+		}
+		if err := e.WriteRow(row); err != nil {
+			return err
+		}
+	}
+
+	return e.End()
+}
+
+func isBinaryType(typeName string) bool {
+	return strings.Contains(typeName, "BLOB") || strings.Contains(typeName, "BINARY")
+}
+
+// getFreeFileName is name.extension, or name-2.extension and so on if that already exists
+func getFreeFileName(name, extension string) string {
+	fileName := fmt.Sprintf("%s.%s", name, extension)
+	for n := 2; ; n++ {
+		if exists, _ := Exists(fileName); !exists {
+			return fileName
+		}
+		fileName = fmt.Sprintf("%s-%d.%s", name, n, extension)
+	}
+}
+
+// FORMATS
+
+// csvExporter writes RFC 4180 csv, with NULL as an empty field
+type csvExporter struct {
+	w       *csv.Writer
+	columns []ExportColumn
+}
+
+func (e *csvExporter) Begin(w io.Writer, _ database.Database, _ string, columns []ExportColumn) error {
+	e.w, e.columns = csv.NewWriter(w), columns
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+
+	return e.w.Write(names)
+}
+
+func (e *csvExporter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = GetCSVRepresentationOfColumn(v, e.columns[i].TypeName)
+	}
+
+	return e.w.Write(record)
+}
+
+func (e *csvExporter) End() error {
+	e.w.Flush()
+
+	return e.w.Error()
+}
+
+// jsonExporter writes an array of objects, or one object per line for ndjson. Keys keep the column order
+type jsonExporter struct {
+	ndjson  bool
+	w       io.Writer
+	columns []ExportColumn
+	keys    []string
+	rows    int
+}
+
+func (e *jsonExporter) Begin(w io.Writer, _ database.Database, _ string, columns []ExportColumn) error {
+	e.w, e.columns = w, columns
+	e.keys = make([]string, len(columns))
+	for i, c := range columns {
+		b, _ := json.Marshal(c.Name)
+		e.keys[i] = string(b)
+	}
+	if e.ndjson {
+		return nil
+	}
+	_, err := io.WriteString(w, "[")
+
+	return err
+}
+
+func (e *jsonExporter) WriteRow(values []interface{}) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		b, err := json.Marshal(GetJSONRepresentationOfInterface(v, e.columns[i].TypeName))
+		if err != nil {
+			return err
+		}
+		fields[i] = e.keys[i] + ":" + string(b)
+	}
+
+	object := "{" + strings.Join(fields, ",") + "}"
+	if e.ndjson {
+		object += "\n"
+	} else if e.rows == 0 {
+		object = "\n  " + object
+	} else {
+		object = ",\n  " + object
+	}
+	e.rows++
+	_, err := io.WriteString(e.w, object)
+
+	return err
+}
+
+func (e *jsonExporter) End() error {
+	if e.ndjson {
+		return nil
+	}
+	_, err := io.WriteString(e.w, "\n]\n")
+
+	return err
+}
+
+// markdownExporter writes a table, escaping pipes and turning line breaks into <br>
+type markdownExporter struct {
+	w io.Writer
+}
+
+func (e *markdownExporter) line(cells []string) error {
+	escape := strings.NewReplacer("|", "\\|", "\r\n", "<br>", "\n", "<br>")
+	for i, c := range cells {
+		cells[i] = escape.Replace(c)
+	}
+	_, err := fmt.Fprintf(e.w, "| %s |\n", strings.Join(cells, " | "))
+
+	return err
+}
+
+func (e *markdownExporter) Begin(w io.Writer, _ database.Database, _ string, columns []ExportColumn) error {
+	e.w = w
+	names := make([]string, len(columns))
+	dashes := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+		dashes[i] = "---"
+	}
+	if err := e.line(names); err != nil {
+		return err
+	}
+
+	return e.line(dashes)
+}
+
+func (e *markdownExporter) WriteRow(values []interface{}) error {
+	cells := make([]string, len(values))
+	for i, v := range values {
+		cells[i] = GetStringRepresentationOfExport(v)
+	}
+
+	return e.line(cells)
+}
+
+func (e *markdownExporter) End() error {
+	return nil
+}
+
+// htmlExporter writes a table element with the table name as its caption
+type htmlExporter struct {
+	w io.Writer
+}
+
+func (e *htmlExporter) Begin(w io.Writer, _ database.Database, table string, columns []ExportColumn) error {
+	e.w = w
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("<table>\n  <caption>%s</caption>\n  <thead>\n    <tr>", html.EscapeString(table)))
+	for _, c := range columns {
+		b.WriteString("<th>" + html.EscapeString(c.Name) + "</th>")
+	}
+	b.WriteString("</tr>\n  </thead>\n  <tbody>\n")
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+func (e *htmlExporter) WriteRow(values []interface{}) error {
+	var b strings.Builder
+	b.WriteString("    <tr>")
+	for _, v := range values {
+		cell := html.EscapeString(GetStringRepresentationOfExport(v))
+		b.WriteString("<td>" + strings.ReplaceAll(cell, "\n", "<br>") + "</td>")
+	}
+	b.WriteString("</tr>\n")
+	_, err := io.WriteString(e.w, b.String())
+
+	return err
+}
+
+func (e *htmlExporter) End() error {
+	_, err := io.WriteString(e.w, "  </tbody>\n</table>\n")
+
+	return err
+}
+
+// sqlExporter writes an INSERT statement per row, quoted for the database the rows came from
+type sqlExporter struct {
+	w      io.Writer
+	db     database.Database
+	prefix string
+}
+
+func (e *sqlExporter) Begin(w io.Writer, db database.Database, table string, columns []ExportColumn) error {
+	e.w, e.db = w, db
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = db.QuoteIdentifier(c.Name)
+	}
+	e.prefix = fmt.Sprintf("INSERT INTO %s (%s) VALUES (", database.QuoteTableName(db, table), strings.Join(names, ", "))
+
+	return nil
+}
+
+func (e *sqlExporter) WriteRow(values []interface{}) error {
+	literals := make([]string, len(values))
+	for i, v := range values {
+		literals[i] = database.ValueLiteral(e.db, v)
+	}
+	_, err := io.WriteString(e.w, e.prefix+strings.Join(literals, ", ")+");\n")
+
+	return err
+}
+
+func (e *sqlExporter) End() error {
+	return nil
+}
+
+// GetStringRepresentationOfExport is the text of a value in text formats, without the rounding the table does
+func GetStringRepresentationOfExport(val interface{}) string {
+	if val == nil {
+		return "NULL"
+	}
+
+	return GetCSVRepresentationOfInterface(val)
+}
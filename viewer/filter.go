@@ -0,0 +1,174 @@
+package viewer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mathaou/termdbms/database"
+	"github.com/mathaou/termdbms/tuiutil"
+)
+
+// FilterPrompt is what the filter key pre-loads into the edit mode text field
+const FilterPrompt = ":filter "
+
+// filterCondition is how a condition starts, with a column and an operator. Anything else is a substring search,
+// unless it's prefixed with where
+var filterCondition = regexp.MustCompile(`(?i)^("[^"]+"|` + "`[^`]+`" + `|\[[^\]]+\]|\w+)\s*` +
+	`(=|!=|<>|<|>|\b(like|glob|regexp|is|in|between|not)\b)`)
+
+// getFilterCondition is the filter as a condition, if it's one. The column it starts with has to be one of columns
+func getFilterCondition(filter string, columns []string) (string, bool) {
+	if fields := strings.Fields(filter); len(fields) > 1 && strings.EqualFold(fields[0], "where") {
+		return strings.TrimSpace(filter[len(fields[0]):]), true
+	}
+
+	match := filterCondition.FindStringSubmatch(filter)
+	if match == nil {
+		return "", false
+	}
+	column := strings.Trim(match[1], "\"`[]")
+	for _, c := range columns {
+		if strings.EqualFold(c, column) {
+			return filter, true
+		}
+	}
+
+	return "", false
+}
+
+// WhereClause is the filter as a where clause, empty if the table isn't filtered
+func (v TableView) WhereClause() string {
+	if v.Filter == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" where (%s)", v.Filter)
+}
+
+// OrderTerms puts the sort column, if there is one, ahead of the given tie breakers
+func (v TableView) OrderTerms(db database.Database, tieBreakers []string) []string {
+	if v.SortColumn == "" {
+		return tieBreakers
+	}
+
+	term := db.QuoteIdentifier(v.SortColumn)
+	if v.SortDescending {
+		term += " desc"
+	}
+
+	return append([]string{term}, tieBreakers...)
+}
+
+// Describe sums up the sort and filter for the header, empty if neither is active
+func (v TableView) Describe() string {
+	var parts []string
+	if v.SortColumn != "" {
+		direction := "asc"
+		if v.SortDescending {
+			direction = "desc"
+		}
+		parts = append(parts, fmt.Sprintf("sort: %s %s", v.SortColumn, direction))
+	}
+	if v.FilterText != "" {
+		parts = append(parts, fmt.Sprintf("filter: %s", v.FilterText))
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+// GetSortMarker is shown in front of the header of the column the table is sorted by
+func (m *TuiModel) GetSortMarker(column string) string {
+	view := m.GetTableView()
+	if m.QueryResult != nil || view.SortColumn != column {
+		return ""
+	}
+
+	if tuiutil.Ascii {
+		if view.SortDescending {
+			return "v "
+		}
+		return "^ "
+	}
+	if view.SortDescending {
+		return "▼ "
+	}
+	return "▲ "
+}
+
+// GetTableView gets the sort and filter of the current table
+func (m *TuiModel) GetTableView() TableView {
+	return m.DefaultTable.Views[m.GetSchemaName()]
+}
+
+// SetTableView applies a new sort and filter to the current table and goes back to the first row.
+// If the database rejects it the old view is kept
+func (m *TuiModel) SetTableView(view TableView) error {
+	t := &m.DefaultTable
+	name := m.GetSchemaName()
+	old := t.Views[name]
+
+	t.Views[name] = view
+	if err := m.LoadPage(0); err != nil {
+		t.Views[name] = old
+		return err
+	}
+	delete(t.RowCounts, name) // recount with the new filter
+
+	m.Viewport.YOffset = 0
+	m.MouseData.Y = HeaderHeight
+
+	return nil
+}
+
+// CycleSort sorts the current table by column ascending, then descending, then not at all
+func (m *TuiModel) CycleSort(column string) {
+	if column == "" || m.QueryResult != nil {
+		return
+	}
+
+	view := m.GetTableView()
+	if view.SortColumn != column {
+		view.SortColumn = column
+		view.SortDescending = false
+	} else if !view.SortDescending {
+		view.SortDescending = true
+	} else {
+		view.SortColumn = ""
+		view.SortDescending = false
+	}
+
+	if err := m.SetTableView(view); err != nil {
+		m.DisplayMessage(fmt.Sprintf("%v", err))
+	}
+}
+
+// ApplyFilter filters the current table. Input that looks like a condition (age > 30) is used as the
+// where clause as is, anything else matches rows with the text in any column. Empty input clears the filter
+func (m *TuiModel) ApplyFilter(input string) error {
+	view := m.GetTableView()
+	view.FilterText = strings.TrimSpace(input)
+	if condition, ok := getFilterCondition(view.FilterText, m.GetHeaders()); ok {
+		view.Filter = condition
+	} else if view.FilterText != "" {
+		view.Filter = database.GetSubstringCondition(m.Table().Database, m.GetHeaders(), view.FilterText)
+	} else {
+		view.Filter = ""
+	}
+
+	return m.SetTableView(view)
+}
+
+// GetHeaderAt gets the name of the column header drawn at x
+func (m *TuiModel) GetHeaderAt(x int) string {
+	headers := m.Data().TableHeadersSlice
+	index := x / m.CellWidth()
+	if m.UI.ExpandColumn > -1 {
+		index = m.UI.ExpandColumn
+	}
+	if index < 0 || index >= len(headers) {
+		return ""
+	}
+
+	return headers[index]
+}
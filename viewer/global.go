@@ -40,52 +40,27 @@ func init() {
 		return nil
 	}
 	GlobalCommands["r"] = func(m *TuiModel) tea.Cmd {
-		if len(m.RedoStack) > 0 && m.QueryResult == nil && m.QueryData == nil { // do this after you get undo working, basically just the same thing reversed
-			// handle undo
-			deepCopy := m.CopyMap()
-			// THE GLOBALIST TAKEOVER
-			deepState := TableState{
-				Database: &database.SQLite{
-					FileName: m.Table().Database.GetFileName(),
-					Database: nil,
-				}, // placeholder for now while testing database copy
-				Data: deepCopy,
+		if m.Journal != nil && len(m.Journal.RedoStack) > 0 && m.QueryResult == nil && m.QueryData == nil {
+			if err := m.Journal.Redo(); err != nil {
+				m.DisplayMessage(fmt.Sprintf("%v", err))
+				return nil
 			}
-			m.UndoStack = append(m.UndoStack, deepState)
-			// handle redo
-			from := m.RedoStack[len(m.RedoStack)-1]
-			to := m.Table()
-			m.SwapTableValues(&from, to)
-			m.Table().Database.CloseDatabaseReference()
-			m.Table().Database.SetDatabaseReference(from.Database.GetFileName())
-
-			m.RedoStack = m.RedoStack[0 : len(m.RedoStack)-1] // pop
+			m.UpdateChangedCells()
+			m.InvalidatePage() // rows get read back from the database
+			m.DefaultTable.RowCounts = make(map[string]int)
 		}
 
 		return nil
 	}
 	GlobalCommands["u"] = func(m *TuiModel) tea.Cmd {
-		if len(m.UndoStack) > 0 && m.QueryResult == nil && m.QueryData == nil {
-			// handle redo
-			deepCopy := m.CopyMap()
-			t := m.Table()
-			// THE GLOBALIST TAKEOVER
-			deepState := TableState{
-				Database: &database.SQLite{
-					FileName: t.Database.GetFileName(),
-					Database: nil,
-				}, // placeholder for now while testing database copy
-				Data: deepCopy,
+		if m.Journal != nil && len(m.Journal.UndoStack) > 0 && m.QueryResult == nil && m.QueryData == nil {
+			if err := m.Journal.Undo(); err != nil {
+				m.DisplayMessage(fmt.Sprintf("%v", err))
+				return nil
 			}
-			m.RedoStack = append(m.RedoStack, deepState)
-			// handle undo
-			from := m.UndoStack[len(m.UndoStack)-1]
-			to := t
-			m.SwapTableValues(&from, to)
-			t.Database.CloseDatabaseReference()
-			t.Database.SetDatabaseReference(from.Database.GetFileName())
-
-			m.UndoStack = m.UndoStack[0 : len(m.UndoStack)-1] // pop
+			m.UpdateChangedCells()
+			m.InvalidatePage() // rows get read back from the database
+			m.DefaultTable.RowCounts = make(map[string]int)
 		}
 
 		return nil
@@ -134,8 +109,10 @@ func init() {
 		if m.UI.RenderSelection {
 			fn, _ := WriteTextFile(m, m.Data().EditTextBuffer)
 			m.WriteMessage(fmt.Sprintf("Wrote selection to %s", fn))
-		} else if m.QueryData != nil || m.QueryResult != nil || database.IsCSV {
-			WriteCSV(m)
+		} else if fn, err := m.Export(ExportCSV, "", 0, -1); err != nil {
+			m.WriteMessage(fmt.Sprintf("%v", err))
+		} else {
+			m.WriteMessage(fmt.Sprintf("Wrote %s", fn))
 		}
 		go Program.Send(tea.KeyMsg{})
 		return nil
@@ -145,6 +122,79 @@ func init() {
 
 		return nil
 	}
+	GlobalCommands["o"] = func(m *TuiModel) tea.Cmd {
+		if m.UI.RenderSelection || m.QueryData != nil || m.QueryResult != nil {
+			return nil
+		}
+		m.CycleSort(m.GetHeaderAt(m.MouseData.X))
+
+		return nil
+	}
+	GlobalCommands["f"] = func(m *TuiModel) tea.Cmd {
+		if m.UI.RenderSelection || m.QueryData != nil || m.QueryResult != nil {
+			return nil
+		}
+		m.UI.EditModeEnabled = true
+		m.TextInput.Model.SetValue(FilterPrompt + m.GetTableView().FilterText)
+
+		return nil
+	}
+	GlobalCommands["/"] = func(m *TuiModel) tea.Cmd {
+		if m.UI.RenderSelection || m.QueryData != nil || m.QueryResult != nil {
+			return nil
+		}
+		OpenSearchPrompt(m, false)
+
+		return nil
+	}
+	GlobalCommands["g"] = func(m *TuiModel) tea.Cmd {
+		if m.UI.RenderSelection || m.QueryData != nil || m.QueryResult != nil {
+			return nil
+		}
+		OpenSearchPrompt(m, true)
+
+		return nil
+	}
+	GlobalCommands["."] = func(m *TuiModel) tea.Cmd { // next search match
+		if m.Search.Term == "" || m.UI.RenderSelection || m.QueryResult != nil {
+			return nil
+		}
+		if err := m.SearchNext(true, false); err != nil {
+			m.DisplayMessage(fmt.Sprintf("%v", err))
+		}
+
+		return nil
+	}
+	GlobalCommands[","] = func(m *TuiModel) tea.Cmd { // previous search match
+		if m.Search.Term == "" || m.UI.RenderSelection || m.QueryResult != nil {
+			return nil
+		}
+		if err := m.SearchNext(false, false); err != nil {
+			m.DisplayMessage(fmt.Sprintf("%v", err))
+		}
+
+		return nil
+	}
+	GlobalCommands["i"] = func(m *TuiModel) tea.Cmd {
+		if m.UI.RenderSelection || m.QueryData != nil || m.QueryResult != nil {
+			return nil
+		}
+		if err := OpenSchemaBrowser(m); err != nil {
+			m.DisplayMessage(fmt.Sprintf("%v", err))
+		}
+
+		return nil
+	}
+	GlobalCommands["e"] = func(m *TuiModel) tea.Cmd {
+		if m.UI.RenderSelection || m.QueryData != nil || m.QueryResult != nil {
+			return nil
+		}
+		if err := OpenChangesPanel(m); err != nil {
+			m.DisplayMessage(fmt.Sprintf("%v", err))
+		}
+
+		return nil
+	}
 	GlobalCommands["b"] = func(m *TuiModel) tea.Cmd {
 		m.UI.BorderToggle = !m.UI.BorderToggle
 
@@ -182,6 +232,42 @@ func init() {
 
 		return nil
 	}
+	GlobalCommands["]"] = func(m *TuiModel) tea.Cmd { // next schema, for databases that have them
+		schemas := m.GetDatabaseSchemas()
+		if len(schemas) == 0 {
+			return nil
+		}
+		schema, _ := database.SplitTableName(m.Table().Database, m.GetSchemaName())
+		next := schemas[(indexOf(schemas, schema)+1)%len(schemas)]
+		m.UI.CurrentTable = m.GetTablesForDatabaseSchema(next)[0]
+
+		// fix spacing and whatnot
+		m.TableStyle = m.TableStyle.Width(m.CellWidth())
+		m.MouseData.Y = HeaderHeight
+		m.MouseData.X = 0
+		m.Viewport.YOffset = 0
+		m.Scroll.ScrollXOffset = 0
+
+		return nil
+	}
+	GlobalCommands["["] = func(m *TuiModel) tea.Cmd { // previous schema
+		schemas := m.GetDatabaseSchemas()
+		if len(schemas) == 0 {
+			return nil
+		}
+		schema, _ := database.SplitTableName(m.Table().Database, m.GetSchemaName())
+		prev := schemas[(indexOf(schemas, schema)-1+len(schemas))%len(schemas)]
+		m.UI.CurrentTable = m.GetTablesForDatabaseSchema(prev)[0]
+
+		// fix spacing and whatnot
+		m.TableStyle = m.TableStyle.Width(m.CellWidth())
+		m.MouseData.Y = HeaderHeight
+		m.MouseData.X = 0
+		m.Viewport.YOffset = 0
+		m.Scroll.ScrollXOffset = 0
+
+		return nil
+	}
 	GlobalCommands["right"] = func(m *TuiModel) tea.Cmd {
 		headers := m.GetHeaders()
 		headersLen := len(headers)
@@ -199,7 +285,7 @@ func init() {
 		return nil
 	}
 	GlobalCommands["s"] = func(m *TuiModel) tea.Cmd {
-		max := len(m.GetSchemaData()[m.GetHeaders()[m.GetColumn()]])
+		max := m.GetRowCount()
 
 		if m.MouseData.Y-HeaderHeight+m.Viewport.YOffset < max-1 {
 			m.MouseData.Y++
@@ -271,6 +357,10 @@ func init() {
 
 		m.UI.RenderSelection = false
 		m.Data().EditTextBuffer = ""
+		if m.UI.SchemaDetail { // back to the schema browser it came from
+			m.UI.SchemaDetail = false
+			m.UI.ShowSchema = true
+		}
 		cmd := m.TextInput.Model.FocusCommand()
 		m.UI.ExpandColumn = -1
 		m.MouseData.Y = m.Scroll.PreScrollYPosition
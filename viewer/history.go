@@ -0,0 +1,247 @@
+package viewer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mathaou/termdbms/database"
+	"github.com/mathaou/termdbms/list"
+)
+
+const (
+	SQLHistoryFile    = "history.ndjson"
+	MaxHistoryEntries = 1000 // older statements are dropped from the file once it has twice this many
+)
+
+// HistoryEntry is a statement run from SQL mode, one line of the history file
+type HistoryEntry struct {
+	Statement string        `json:"statement"`
+	Time      time.Time     `json:"time"`
+	Database  string        `json:"database"` // the file, or the connection string without its password
+	Duration  time.Duration `json:"duration"` // nanoseconds
+	Rows      int64         `json:"rows"`     // read by a query, changed by anything else
+	Error     string        `json:"error,omitempty"`
+}
+
+// HistoryState is every statement run so far, oldest first
+type HistoryState struct {
+	Entries []HistoryEntry
+	List    list.Model
+	Recall  int // how many statements back up/down went in the SQL buffer, 0 when it isn't holding one
+}
+
+func (h HistoryEntry) Title() string {
+	return strings.Join(strings.Fields(h.Statement), " ")
+}
+
+func (h HistoryEntry) Description() string {
+	outcome := fmt.Sprintf("%d row(s)", h.Rows)
+	if h.Error != "" {
+		outcome = "error: " + strings.Join(strings.Fields(h.Error), " ")
+	}
+
+	duration := h.Duration.Round(time.Millisecond)
+	if duration == 0 {
+		duration = h.Duration.Round(time.Microsecond)
+	}
+
+	return fmt.Sprintf("%s | %s | %s | %s", h.Time.Format("2006-01-02 15:04:05"), h.Database, duration, outcome)
+}
+
+func (h HistoryEntry) FilterValue() string {
+	return h.Title()
+}
+
+// GetHistoryFile is where statements are recorded, in the config directory next to the snippets
+func GetHistoryFile() string {
+	return filepath.Join(GetConfigDirectory(), SQLHistoryFile)
+}
+
+// ReadHistory loads the recorded statements, skipping lines it can't read. A file that grew past twice
+// MaxHistoryEntries is cut back to the newest MaxHistoryEntries
+func ReadHistory() ([]HistoryEntry, error) {
+	historyFile := GetHistoryFile()
+	contents, err := os.ReadFile(historyFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(contents)+1) // statements can be long
+	for scanner.Scan() {
+		var e HistoryEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil && e.Statement != "" {
+			entries = append(entries, e)
+		}
+	}
+
+	if len(entries) > 2*MaxHistoryEntries {
+		entries = entries[len(entries)-MaxHistoryEntries:]
+		var b bytes.Buffer
+		for _, e := range entries {
+			line, _ := json.Marshal(e)
+			b.Write(append(line, '\n'))
+		}
+		tmp := historyFile + ".tmp"
+		if err = os.WriteFile(tmp, b.Bytes(), 0o600); err == nil {
+			err = os.Rename(tmp, historyFile)
+		}
+	}
+
+	return entries, err
+}
+
+// AppendHistory adds a statement to the end of the history file. Several termdbms can append to it at once
+func AppendHistory(e HistoryEntry) error {
+	historyFile := GetHistoryFile()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(historyFile), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) // statements may hold data
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// RecordHistory remembers a statement that finished, in memory and in the history file
+func (m *TuiModel) RecordHistory(q *QueryState, rows int64, err error) {
+	e := HistoryEntry{
+		Statement: strings.TrimSpace(q.Statement),
+		Time:      q.Started,
+		Database:  GetHistoryDatabaseName(m.InitialFileName),
+		Duration:  time.Since(q.Started),
+		Rows:      rows,
+	}
+	if q.Cancelled && err != nil {
+		e.Error = "cancelled"
+	} else if err != nil {
+		e.Error = err.Error()
+	}
+	m.History.Entries = append(m.History.Entries, e)
+
+	if err := AppendHistory(e); err != nil {
+		m.WriteMessage(fmt.Sprintf("Could not write SQL history to %s: %v", GetHistoryFile(), err))
+	}
+}
+
+var postgresPassword = regexp.MustCompile(`(password=)('[^']*'|\S+)`)
+
+// GetHistoryDatabaseName is what the history says a statement ran against. Files get their full path,
+// connection strings are kept without their password
+func GetHistoryDatabaseName(name string) string {
+	switch database.DriverString {
+	case database.DriverMySQL: // user:password@tcp(host:3306)/schema
+		if at := strings.LastIndex(name, "@"); at > -1 {
+			if colon := strings.Index(name[:at], ":"); colon > -1 {
+				name = name[:colon] + ":xxxxx" + name[at:]
+			}
+		}
+		return name
+	case database.DriverPostgres:
+		if u, err := url.Parse(name); err == nil && u.Scheme != "" {
+			return u.Redacted()
+		}
+		return postgresPassword.ReplaceAllString(name, "${1}xxxxx")
+	}
+
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+
+	return name
+}
+
+// OpenHistoryBrowser lists the statements run so far, newest first
+func OpenHistoryBrowser(m *TuiModel) {
+	items := make([]list.Item, len(m.History.Entries))
+	for i, e := range m.History.Entries {
+		items[len(items)-1-i] = e
+	}
+
+	m.History.List = list.NewModel(items, list.NewDefaultDelegate(), TUIWidth, TUIHeight)
+	m.History.List.Title = "SQL History"
+	m.History.List.SetFilteringEnabled(true)
+	m.History.List.SetShowPagination(true)
+	m.History.List.SetShowTitle(true)
+	m.UI.ShowHistory = true
+}
+
+func ShowHistory(m *TuiModel) string {
+	return m.History.List.View()
+}
+
+func HandleHistoryEvents(m *TuiModel, str string, command *tea.Cmd, msg tea.Msg) {
+	if (str == "q" || str == "esc" || str == "enter") && m.History.List.FilterState() != list.Filtering {
+		if e, ok := m.History.List.SelectedItem().(HistoryEntry); ok && str == "enter" {
+			ExitToDefaultView(m)
+			CreatePopulatedBuffer(m, nil, e.Statement)
+			m.UI.SQLEdit = true
+			return
+		}
+		m.UI.ShowHistory = false
+		m.History.List.ResetFilter()
+	} else {
+		m.History.List, *command = m.History.List.Update(msg)
+	}
+}
+
+// RecallHistory puts the previous (up) or next statement of the history in the SQL buffer. It only does while
+// the buffer is empty or holds a recalled statement as it was, otherwise up and down move the cursor
+func RecallHistory(m *TuiModel, up bool) bool {
+	entries := m.History.Entries
+	buffer := strings.TrimSpace(m.Data().EditTextBuffer)
+	if buffer == "" {
+		m.History.Recall = 0
+	} else if m.History.Recall == 0 || m.History.Recall > len(entries) ||
+		buffer != strings.TrimSpace(entries[len(entries)-m.History.Recall].Statement) {
+		return false
+	}
+
+	recall := m.History.Recall
+	for { // the same statement run several times in a row is recalled once
+		if up {
+			recall++
+		} else {
+			recall--
+		}
+		if recall < 0 || recall > len(entries) {
+			return true
+		}
+		if recall == 0 || strings.TrimSpace(entries[len(entries)-recall].Statement) != buffer {
+			break
+		}
+	}
+
+	ExitToDefaultView(m)
+	if recall == 0 {
+		CreateEmptyBuffer(m, nil)
+	} else {
+		CreatePopulatedBuffer(m, nil, entries[len(entries)-recall].Statement)
+	}
+	m.UI.SQLEdit = true
+	m.History.Recall = recall
+
+	return true
+}
@@ -2,11 +2,9 @@ package viewer
 
 import (
 	"database/sql"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"math/rand"
-	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -30,7 +28,15 @@ func ExitToDefaultView(m *TuiModel) {
 	m.UI.EditModeEnabled = false
 	m.UI.FormatModeEnabled = false
 	m.UI.SQLEdit = false
+	m.UI.InsertForm = false
+	m.UI.SearchPrompt = false
+	m.UI.SearchAll = false
+	m.UI.ShowSearchResults = false
+	m.UI.ShowSchema = false
+	m.UI.SchemaDetail = false
 	m.UI.ShowClipboard = false
+	m.UI.ShowChanges = false
+	m.UI.ShowHistory = false
 	m.UI.CanFormatScroll = false
 	m.Format.CursorY = 0
 	m.Format.CursorX = 0
@@ -39,6 +45,7 @@ func ExitToDefaultView(m *TuiModel) {
 	m.Format.RunningOffsets = nil
 	m.FormatInput.Model.Reset()
 	m.TextInput.Model.Reset()
+	m.TextInput.Model.Prompt = tuiutil.DefaultPrompt
 	m.Viewport.YOffset = 0
 }
 
@@ -74,6 +81,10 @@ func EditEnter(m *TuiModel) {
 		input    string
 	)
 
+	if m.UI.SearchPrompt {
+		handleSearch(m, i)
+		return
+	}
 	if i == ":q" { // quit mod mode
 		ExitToDefaultView(m)
 		return
@@ -83,7 +94,11 @@ func EditEnter(m *TuiModel) {
 		raw, _, _ := m.GetSelectedOption()
 		original = raw
 		if input == ":d" && m.QueryData != nil && m.QueryResult != nil {
-			m.DefaultTable.Database.SetDatabaseReference(m.QueryResult.Database.GetFileName())
+			if err := m.DefaultTable.Database.SetDatabaseReference(m.QueryResult.Database.GetFileName()); err != nil {
+				ExitToDefaultView(m)
+				m.DisplayMessage(fmt.Sprintf("%v", err))
+				return
+			}
 			m.QueryData = nil
 			m.QueryResult = nil
 			var c *sql.Rows
@@ -99,11 +114,35 @@ func EditEnter(m *TuiModel) {
 			ExitToDefaultView(m)
 			return
 		}
+		if input == ":export" || strings.HasPrefix(input, ":export ") { // works for query results too
+			handleExport(m, strings.TrimPrefix(input, ":export"))
+			return
+		}
 		if m.QueryData != nil {
 			m.TextInput.Model.SetValue("")
 			m.WriteMessage("Cannot manipulate database through UI while query results are being displayed.")
 			return
 		}
+		if input == ":diff" || strings.HasPrefix(input, ":diff ") {
+			handleDiff(m, strings.TrimSpace(strings.TrimPrefix(input, ":diff")))
+			return
+		}
+		if input == ":d" { // back to the table as it is in the database
+			m.Search.Term = ""
+			if err := m.SetTableView(TableView{}); err != nil {
+				m.DisplayMessage(fmt.Sprintf("%v", err))
+				return
+			}
+			ExitToDefaultView(m)
+			return
+		} else if strings.HasPrefix(input, strings.TrimSpace(FilterPrompt)) {
+			if err := m.ApplyFilter(strings.TrimPrefix(input, strings.TrimSpace(FilterPrompt))); err != nil {
+				m.DisplayMessage(fmt.Sprintf("%v", err))
+				return
+			}
+			ExitToDefaultView(m)
+			return
+		}
 		if input == ":h" {
 			m.DisplayMessage(GetHelpText())
 			return
@@ -127,6 +166,23 @@ func EditEnter(m *TuiModel) {
 			CreateEmptyBuffer(m, original)
 			m.UI.SQLEdit = true
 			return
+		} else if (input == ":insert" || input == ":dup" || input == ":delete") && database.ReadOnly {
+			ExitToDefaultView(m)
+			m.WriteMessage(fmt.Sprintf("%v", readOnlyError()))
+			return
+		} else if (input == ":insert" || input == ":dup" || input == ":delete") && m.IsReadOnlyTable() {
+			ExitToDefaultView(m)
+			m.WriteMessage(fmt.Sprintf("%s is a view and can't be edited.", m.GetSchemaName()))
+			return
+		} else if input == ":insert" {
+			OpenInsertForm(m, nil)
+			return
+		} else if input == ":dup" && original != nil {
+			OpenInsertForm(m, m.GetRowData())
+			return
+		} else if input == ":delete" && original != nil {
+			DeleteSelectedRow(m)
+			return
 		} else if input == ":clip" {
 			ExitToDefaultView(m)
 			if len(m.ClipboardList.Items()) == 0 {
@@ -134,16 +190,27 @@ func EditEnter(m *TuiModel) {
 			}
 			m.UI.ShowClipboard = true
 			return
+		} else if input == ":history" {
+			openHistory(m)
+			return
 		}
 	} else {
 		input = d.EditTextBuffer
 		original = m.FormatInput.Original
-		sqlFlags := m.UI.SQLEdit && !(i == ":exec" || strings.HasPrefix(i, ":stow"))
-		formatFlags := m.UI.FormatModeEnabled && !(i == ":w" || i == ":wq" || i == ":s" || i == ":s!")
+		sqlFlags := m.UI.SQLEdit && !(i == ":exec" || i == ":history" || strings.HasPrefix(i, ":stow"))
+		formatFlags := m.UI.FormatModeEnabled && !(i == ":w" || i == ":wq" || i == ":s" || i == ":s!" || strings.HasPrefix(i, ":s "))
 		if formatFlags && sqlFlags {
 			m.TextInput.Model.SetValue("")
 			return
 		}
+		if m.UI.InsertForm {
+			if i == ":exec" {
+				handleInsertForm(m, input)
+			} else {
+				m.TextInput.Model.SetValue("")
+			}
+			return
+		}
 	}
 
 	if original != nil && *original == input {
@@ -151,24 +218,53 @@ func EditEnter(m *TuiModel) {
 		return
 	}
 
-	if i == ":s" { // saves copy, default filename + :s _____ will save with that filename in cwd
+	if i == ":s" || strings.HasPrefix(i, ":s ") { // saves copy, default filename + :s _____ will save with that filename
+		target := strings.TrimSpace(strings.TrimPrefix(i, ":s"))
 		ExitToDefaultView(m)
-		newFileName, err := Serialize(m)
-		if err != nil {
-			m.DisplayMessage(fmt.Sprintf("%v", err))
-		} else {
-			m.DisplayMessage(fmt.Sprintf("Wrote copy of database to filepath %s.", newFileName))
+		save := func(m *TuiModel) {
+			newFileName, err := Serialize(m, target)
+			if err != nil {
+				m.DisplayMessage(fmt.Sprintf("%v", err))
+			} else {
+				m.DisplayMessage(fmt.Sprintf("Wrote copy of database to filepath %s.", newFileName))
+			}
+		}
+		if exists, _ := Exists(target); exists {
+			m.Confirm(fmt.Sprintf("%s already exists. Overwrite it?", target), save)
+			return
 		}
+		save(m)
 
 		return
-	} else if i == ":s!" { // overwrites original - should add confirmation dialog!
+	} else if i == ":s!" { // overwrites original
 		ExitToDefaultView(m)
-		err := SerializeOverwrite(m)
-		if err != nil {
-			m.DisplayMessage(fmt.Sprintf("%v", err))
-		} else {
-			m.DisplayMessage("Overwrote original database file with changes.")
+		if database.ReadOnly {
+			m.WriteMessage(fmt.Sprintf("%v", readOnlyError()))
+			return
 		}
+		if _, ok := m.DefaultTable.Database.(*database.SQLite); !ok {
+			m.DisplayMessage(fmt.Sprintf("%v", serializationError()))
+			return
+		}
+		question := fmt.Sprintf("Overwrite %s with your changes?", m.InitialFileName)
+		if database.IsCSV {
+			var files []string
+			for _, fileName := range database.CSVFiles {
+				files = append(files, fileName)
+			}
+			sort.Strings(files)
+			question = fmt.Sprintf("Overwrite %s with your changes?", strings.Join(files, ", "))
+		}
+		m.Confirm(question, func(m *TuiModel) {
+			err := SerializeOverwrite(m)
+			if err != nil {
+				m.DisplayMessage(fmt.Sprintf("%v", err))
+			} else if database.IsCSV {
+				m.DisplayMessage("Wrote the tables back to their csv files.")
+			} else {
+				m.DisplayMessage("Overwrote original database file with changes.")
+			}
+		})
 
 		return
 	}
@@ -176,6 +272,8 @@ func EditEnter(m *TuiModel) {
 	if m.UI.SQLEdit {
 		if i == ":exec" {
 			handleSQLMode(m, input)
+		} else if i == ":history" {
+			openHistory(m)
 		} else if strings.HasPrefix(i, ":stow") {
 			if len(input) > 0 {
 				split := strings.Split(i, " ")
@@ -189,23 +287,17 @@ func EditEnter(m *TuiModel) {
 					Query: input,
 					Name:  title,
 				})
-				b, _ := json.Marshal(m.Clipboard)
-				snippetsFile := fmt.Sprintf("%s/%s", HiddenTmpDirectoryName, SQLSnippetsFile)
-				f, _ := os.OpenFile(snippetsFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0775)
-				f.Write(b)
-				f.Close()
-				m.WriteMessage(fmt.Sprintf("Wrote SQL snippet %s to %s. Total count is %d", title, snippetsFile, len(m.ClipboardList.Items())+1))
+				if snippetsFile, err := WriteSnippets(m.Clipboard); err != nil {
+					m.WriteMessage(fmt.Sprintf("Could not write SQL snippet %s to %s: %v", title, snippetsFile, err))
+				} else {
+					m.WriteMessage(fmt.Sprintf("Wrote SQL snippet %s to %s. Total count is %d", title, snippetsFile, len(m.ClipboardList.Items())+1))
+				}
 			}
 			m.TextInput.Model.SetValue("")
 		}
 		return
 	}
 
-	old, n := populateUndo(m)
-	if old == n || n != m.DefaultTable.Database.GetFileName() {
-		panic(errors.New("could not get database file name"))
-	}
-
 	if _, err := FormatJson(input); err == nil { // if json uglify
 		input = strings.ReplaceAll(input, " ", "")
 		input = strings.ReplaceAll(input, "\n", "")
@@ -213,10 +305,31 @@ func EditEnter(m *TuiModel) {
 		input = strings.ReplaceAll(input, "\r", "")
 	}
 
+	if database.ReadOnly {
+		ExitToDefaultView(m)
+		m.WriteMessage(fmt.Sprintf("%v", readOnlyError()))
+		return
+	}
+	if m.IsReadOnlyTable() {
+		ExitToDefaultView(m)
+		m.WriteMessage(fmt.Sprintf("%s is a view and can't be edited.", m.GetSchemaName()))
+		return
+	}
+
 	u := GetInterfaceFromString(input, original)
-	database.ProcessSqlQueryForDatabaseType(&database.Update{
-		Update: u,
-	}, m.GetRowData(), m.GetSchemaName(), m.GetSelectedColumnName(), &t.Database)
+	err := m.RecordEdit(func() error {
+		return database.ProcessSqlQueryForDatabaseType(&database.Update{
+			Update: u,
+		}, m.GetRowData(), m.GetSchemaName(), m.GetSelectedColumnName(), &t.Database)
+	})
+	if err != nil { // the cell keeps its value
+		ExitToDefaultView(m)
+		m.DisplayMessage(fmt.Sprintf("Could not update %s of %s:\n%v", m.GetSelectedColumnName(), m.GetSchemaName(), err))
+		return
+	}
+	if key := t.Database.GetTableKey(m.GetSchemaName()); key.Warning != "" {
+		m.WriteMessage(key.Warning)
+	}
 
 	m.UI.EditModeEnabled = false
 	d.EditTextBuffer = ""
@@ -229,104 +342,50 @@ func EditEnter(m *TuiModel) {
 	}
 }
 
+// handleSQLMode runs the statement in the SQL buffer, after asking if it deletes, updates or changes the schema
 func handleSQLMode(m *TuiModel, input string) {
-	if m.QueryResult != nil {
-		m.QueryResult = nil
-	}
-	m.QueryResult = &TableState{ // perform query
-		Database: m.Table().Database,
-		Data:     make(map[string]interface{}),
+	if keyword := GetStatementKeyword(input); database.ReadOnly && !QueryStatements[keyword] {
+		ExitToDefaultView(m)
+		m.DisplayMessage(fmt.Sprintf("%v\nOnly %s statements can run.", readOnlyError(), getQueryStatementList()))
+		return
+	} else if DestructiveStatements[keyword] && CanTryStatement(m.DefaultTable.Database) {
+		m.StartQuery(input)
+		m.Query.DryRun = true // FinishQuery asks before it runs for real
+		return
+	} else if DestructiveStatements[keyword] {
+		m.Confirm(getStatementConfirmation(m, input, -1), func(m *TuiModel) {
+			m.StartQuery(input)
+		})
+		return
 	}
-	m.QueryData = &UIData{}
-
-	firstword := strings.ToLower(strings.Split(input, " ")[0])
-	if exec := firstword == "update" ||
-		firstword == "delete" ||
-		firstword == "insert"; exec {
-		m.QueryData = nil
-		m.QueryResult = nil
-		populateUndo(m)
-		_, err := m.DefaultTable.Database.GetDatabaseReference().Exec(input)
-		if err != nil {
-			ExitToDefaultView(m)
-			m.DisplayMessage(fmt.Sprintf("%v", err))
-			return
-		}
-		var c *sql.Rows
-		defer func() {
-			if c != nil {
-				c.Close()
-			}
-		}()
-		err = m.SetModel(c, m.DefaultTable.Database.GetDatabaseReference())
-		if err != nil {
-			m.DisplayMessage(fmt.Sprintf("%v", err))
-		} else {
-			ExitToDefaultView(m)
-		}
-	} else { // query
-		c, err := m.QueryResult.Database.GetDatabaseReference().Query(input)
-		defer func() {
-			if c != nil {
-				c.Close()
-			}
-		}()
-		if err != nil {
-			m.QueryResult = nil
-			m.QueryData = nil
-			ExitToDefaultView(m)
-			m.DisplayMessage(fmt.Sprintf("%v", err))
-			return
-		}
-
-		i := 0
 
-		m.QueryData.TableHeaders = make(map[string][]string)
-		m.QueryData.TableIndexMap = make(map[int]string)
-		m.QueryData.TableSlices = make(map[string][]interface{})
-		m.QueryData.TableHeadersSlice = []string{}
+	m.StartQuery(input)
+}
 
-		m.PopulateDataForResult(c, &i, QueryResultsTableName)
-		ExitToDefaultView(m)
-		m.UI.EditModeEnabled = false
-		m.UI.CurrentTable = 1
-		m.Data().EditTextBuffer = ""
-		m.FormatInput.Model.SetValue("")
+func openHistory(m *TuiModel) {
+	ExitToDefaultView(m)
+	if len(m.History.Entries) == 0 {
+		m.WriteMessage("No SQL statements have been run yet.")
+		return
 	}
+	OpenHistoryBrowser(m)
 }
 
-func populateUndo(m *TuiModel) (old string, new string) {
-	if len(m.UndoStack) >= 10 {
-		ref := m.UndoStack[len(m.UndoStack)-1]
-		err := os.Remove(ref.Database.GetFileName())
-		if err != nil {
-			fmt.Printf("%v", err)
-			os.Exit(1)
-		}
-		m.UndoStack = m.UndoStack[1:] // need some more complicated logic to handle dereferencing?
+// RecordEdit runs edit as a single step in the undo journal, if the database has one
+func (m *TuiModel) RecordEdit(edit func() error) error {
+	if database.ReadOnly {
+		return readOnlyError()
 	}
-
-	switch m.DefaultTable.Database.(type) {
-	case *database.SQLite:
-		deepCopy := m.CopyMap()
-		// THE GLOBALIST TAKEOVER
-		deepState := TableState{
-			Database: &database.SQLite{
-				FileName: m.DefaultTable.Database.GetFileName(),
-				Database: nil,
-			},
-			Data: deepCopy,
-		}
-		m.UndoStack = append(m.UndoStack, deepState)
-		old = m.DefaultTable.Database.GetFileName()
-		dst, _, _ := CopyFile(old)
-		new = dst
-		m.DefaultTable.Database.CloseDatabaseReference()
-		m.DefaultTable.Database.SetDatabaseReference(dst)
-		break
-	default:
-		break
+	if m.Journal == nil {
+		return edit()
 	}
 
-	return old, new
+	err := m.Journal.Record(edit)
+	m.UpdateChangedCells()
+
+	return err
+}
+
+func readOnlyError() error {
+	return fmt.Errorf("The database was opened with -read-only and can't be changed.")
 }
@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
+	"path/filepath"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/mathaou/termdbms/database"
@@ -22,46 +22,13 @@ func (m *TuiModel) WriteMessage(s string) {
 	}
 }
 
-func (m *TuiModel) CopyMap() (to map[string]interface{}) {
-	from := m.Table().Data
-	to = map[string]interface{}{}
-
-	for k, v := range from {
-		if copyValues, ok := v.(map[string][]interface{}); ok {
-			columnNames := m.Data().TableHeaders[k]
-			columnValues := make(map[string][]interface{})
-			// golang wizardry
-			columns := make([]interface{}, len(columnNames))
-
-			for i := range columns {
-				columns[i] = copyValues[columnNames[i]]
-			}
-
-			for i, colName := range columnNames {
-				val := columns[i].([]interface{})
-				buffer := make([]interface{}, len(val))
-				for k := range val {
-					buffer[k] = val[k]
-				}
-				columnValues[colName] = append(columnValues[colName], buffer)
-			}
-
-			to[k] = columnValues // data for schema, organized by column
-		}
-	}
-
-	return to
-}
-
 // GetNewModel returns a TuiModel struct with some fields set
 func GetNewModel(baseFileName string, db *sql.DB) TuiModel {
 	m := TuiModel{
 		DefaultTable: TableState{
-			Database: &database.SQLite{
-				FileName: baseFileName,
-				Database: db,
-			},
-			Data: make(map[string]interface{}),
+			Database: database.NewDatabase(baseFileName, db),
+			Data:     make(map[string]interface{}),
+			Views:    make(map[string]TableView),
 		},
 		Format: FormatState{
 			EditSlices:     nil,
@@ -96,7 +63,10 @@ func GetNewModel(baseFileName string, db *sql.DB) TuiModel {
 	}
 	m.FormatInput.Model.Prompt = ""
 
-	snippetsFile := fmt.Sprintf("%s/%s", HiddenTmpDirectoryName, SQLSnippetsFile)
+	snippetsFile := GetSnippetsFile()
+	if exists, _ := Exists(snippetsFile); !exists { // older versions kept them where they were started
+		snippetsFile = filepath.Join(HiddenTmpDirectoryName, SQLSnippetsFile)
+	}
 
 	exists, _ := Exists(snippetsFile)
 	if exists {
@@ -109,6 +79,7 @@ func GetNewModel(baseFileName string, db *sql.DB) TuiModel {
 	}
 
 	m.ClipboardList = list.NewModel(m.Clipboard, itemDelegate{}, 0, 0)
+	m.History.Entries, _ = ReadHistory() // without it there's just no history to browse
 
 	m.ClipboardList.Title = "SQL Snippets"
 	m.ClipboardList.SetFilteringEnabled(true)
@@ -118,7 +89,9 @@ func GetNewModel(baseFileName string, db *sql.DB) TuiModel {
 	return m
 }
 
-// SetModel creates a model to be used by bubbletea using some golang wizardry
+// SetModel creates a model to be used by bubbletea using some golang wizardry. Only the table names and headers
+// are read here, rows get loaded a page at a time as the viewport moves (see LoadPage). The model is only replaced
+// once all of them were read, a failed query leaves it as it was
 func (m *TuiModel) SetModel(c *sql.Rows, db *sql.DB) error {
 	var err error
 
@@ -131,38 +104,84 @@ func (m *TuiModel) SetModel(c *sql.Rows, db *sql.DB) error {
 		return err
 	}
 
-	defer rows.Close()
-
-	// for each schema
-	for rows.Next() {
-		var schemaName string
-		rows.Scan(&schemaName)
+	t := m.Table()
+	d := m.Data()
+	readOnly := make(map[string]bool)
+
+	var schemaNames []string
+	for rows.Next() { // read these up front, sqlite only gets one connection
+		var (
+			schemaName string
+			view       bool
+		)
+		rows.Scan(&schemaName, &view)
+		schemaNames = append(schemaNames, schemaName)
+		readOnly[schemaName] = view
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
 
-		// couldn't get prepared statements working and gave up because it was very simple
-		var statement strings.Builder
-		statement.WriteString("select * from ")
-		statement.WriteString(schemaName)
-		getAll := statement.String()
+	tableHeaders := make(map[string][]string)
+	tableIndexMap := make(map[int]string)
 
+	// for each schema
+	for _, schemaName := range schemaNames {
 		if c != nil {
 			c.Close()
 			c = nil
 		}
-		c, err = db.Query(getAll)
+		c, err = db.Query(fmt.Sprintf("select * from %s limit 0", database.QuoteTableName(t.Database, schemaName)))
 		if err != nil {
-			panic(err)
+			return err
 		}
+		columnNames, _ := c.Columns()
+		c.Close()
+
+		// onto the next schema
+		indexMap++
+		tableHeaders[schemaName] = columnNames // headers for the schema, for later reference
+		// mapping between schema and an int ( since maps aren't deterministic), for later reference
+		tableIndexMap[indexMap] = schemaName
+	}
 
-		m.PopulateDataForResult(c, &indexMap, schemaName)
+	if _, ok := t.Database.(*database.SQLite); ok && !database.ReadOnly { // (re)install the undo triggers, the schema may have changed
+		if m.Journal == nil {
+			m.Journal, err = database.NewJournal(t.Database)
+		} else {
+			err = m.Journal.Refresh()
+		}
+		if err != nil {
+			return err
+		}
 	}
 
+	t.Data = make(map[string]interface{})
+	t.PageOffset = 0
+	t.RowCounts = make(map[string]int)
+	d.ReadOnly = readOnly
+	d.TableHeaders = tableHeaders
+	d.TableIndexMap = tableIndexMap
+
 	// set the first table to be initial view
 	m.UI.CurrentTable = 1
 
-	return nil
+	return m.LoadPage(0)
+}
+
+// PopulateDataForResult puts a result set, read in full by ReadColumns, into the query result view
+func (m *TuiModel) PopulateDataForResult(columnNames []string, columnValues map[string][]interface{}, indexMap *int, schemaName string) {
+	// onto the next schema
+	*indexMap++
+	m.QueryResult.Data[schemaName] = columnValues
+	m.QueryData.TableHeaders[schemaName] = columnNames // headers for the schema, for later reference
+	m.QueryData.TableIndexMap[*indexMap] = schemaName
 }
 
-func (m *TuiModel) PopulateDataForResult(c *sql.Rows, indexMap *int, schemaName string) {
+// ReadColumns scans every remaining row of c, organized by column. The hidden row id column is
+// kept in the values but left out of the returned headers
+func ReadColumns(c *sql.Rows) ([]string, map[string][]interface{}) {
 	columnNames, _ := c.Columns()
 	columnValues := make(map[string][]interface{})
 
@@ -179,43 +198,20 @@ func (m *TuiModel) PopulateDataForResult(c *sql.Rows, indexMap *int, schemaName
 
 		for i, colName := range columnNames {
 			val := columnPointers[i].(*interface{})
+			if b, ok := (*val).([]byte); ok { // mysql hands back text columns as raw bytes
+				*val = string(b)
+			}
 			columnValues[colName] = append(columnValues[colName], *val)
 		}
 	}
 
-	// onto the next schema
-	*indexMap++
-	if m.QueryResult != nil && m.QueryData != nil {
-		m.QueryResult.Data[schemaName] = columnValues
-		m.QueryData.TableHeaders[schemaName] = columnNames // headers for the schema, for later reference
-		m.QueryData.TableIndexMap[*indexMap] = schemaName
-		return
-	}
-	m.Table().Data[schemaName] = columnValues       // data for schema, organized by column
-	m.Data().TableHeaders[schemaName] = columnNames // headers for the schema, for later reference
-	// mapping between schema and an int ( since maps aren't deterministic), for later reference
-	m.Data().TableIndexMap[*indexMap] = schemaName
-}
-
-func (m *TuiModel) SwapTableValues(f, t *TableState) {
-	from := &f.Data
-	to := &t.Data
-	for k, v := range *from {
-		if copyValues, ok := v.(map[string][]interface{}); ok {
-			columnNames := m.Data().TableHeaders[k]
-			columnValues := make(map[string][]interface{})
-			// golang wizardry
-			columns := make([]interface{}, len(columnNames))
-
-			for i := range columns {
-				columns[i] = copyValues[columnNames[i]][0]
-			}
-
-			for i, colName := range columnNames {
-				columnValues[colName] = columns[i].([]interface{})
-			}
-
-			(*to)[k] = columnValues // data for schema, organized by column
+	// the row id is kept with the data so edits can find the row, but it isn't a real column
+	for i, colName := range columnNames {
+		if colName == database.RowIDColumn {
+			columnNames = append(columnNames[:i:i], columnNames[i+1:]...)
+			break
 		}
 	}
+
+	return columnNames, columnValues
 }
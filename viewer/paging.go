@@ -0,0 +1,189 @@
+package viewer
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mathaou/termdbms/database"
+)
+
+// PageSize is how many rows of the current table are kept in memory at once
+const PageSize = 256
+
+// RowCountMsg carries a row count that finished in the background. Counts is the map it was
+// requested for, so a count that finishes after the model was reset goes nowhere
+type RowCountMsg struct {
+	Counts map[string]int
+	Table  string
+	Count  int
+}
+
+// GetPageQuery builds the statement for a window of rows with the table's sort and filter applied,
+// falling back to key order so pages are stable. A negative limit reads every row
+func GetPageQuery(db database.Database, tableName string, view TableView, offset, limit int) string {
+	var (
+		statement strings.Builder
+		order     []string
+	)
+
+	key := db.GetTableKey(tableName)
+	statement.WriteString("select ")
+	if key.RowID != "" { // keyless tables get edited by row id
+		statement.WriteString(fmt.Sprintf("%s as %s, ", key.RowID, database.RowIDColumn))
+		order = append(order, key.RowID)
+	} else if key.Order != "" { // or at least paged in a stable order
+		order = append(order, key.Order)
+	}
+	for _, v := range key.Columns {
+		order = append(order, db.QuoteIdentifier(v))
+	}
+	order = view.OrderTerms(db, order)
+	statement.WriteString("* from ")
+	statement.WriteString(database.QuoteTableName(db, tableName))
+	statement.WriteString(view.WhereClause())
+	if len(order) > 0 {
+		statement.WriteString(" order by ")
+		statement.WriteString(strings.Join(order, ", "))
+	}
+	if limit >= 0 {
+		statement.WriteString(fmt.Sprintf(" limit %d offset %d", limit, offset))
+	} else if offset > 0 { // not every database takes an offset without a limit
+		statement.WriteString(fmt.Sprintf(" limit %d offset %d", math.MaxInt64, offset))
+	}
+
+	return statement.String()
+}
+
+// LoadPage replaces whatever rows are cached with PageSize rows of the current table, starting at offset
+func (m *TuiModel) LoadPage(offset int) error {
+	t := &m.DefaultTable
+	schemaName := m.DefaultData.TableIndexMap[m.UI.CurrentTable]
+	if schemaName == "" {
+		return nil
+	}
+
+	c, err := t.Database.GetDatabaseReference().Query(GetPageQuery(t.Database, schemaName, t.Views[schemaName], offset, PageSize))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	_, columnValues := ReadColumns(c)
+	for k := range t.Data { // only the current table's page stays cached
+		delete(t.Data, k)
+	}
+	t.Data[schemaName] = columnValues // data for schema, organized by column
+	t.PageOffset = offset
+
+	return c.Err()
+}
+
+// InvalidatePage drops the cached rows so the next SetViewSlices reads them from the database again
+func (m *TuiModel) InvalidatePage() {
+	for k := range m.DefaultTable.Data {
+		delete(m.DefaultTable.Data, k)
+	}
+}
+
+// EnsurePageLoaded fetches a new page if the rows in the viewport aren't cached
+func (m *TuiModel) EnsurePageLoaded() {
+	if m.QueryResult != nil { // query results are held in full
+		return
+	}
+
+	t := m.Table()
+	first := Max(m.Viewport.YOffset, 0)
+	last := first + m.Viewport.Height
+	if schemaData, ok := t.Data[m.GetSchemaName()]; ok {
+		loaded := pageLength(schemaData.(map[string][]interface{}))
+		if first >= t.PageOffset && (last <= t.PageOffset+loaded || loaded < PageSize) {
+			return
+		}
+	}
+
+	if err := m.LoadPage(Max(first-PageSize/4, 0)); err != nil { // keep some rows above the viewport too
+		m.DisplayMessage(fmt.Sprintf("%v", err))
+	}
+}
+
+// GetPageOffset gets the absolute row of the first row in GetColumnData
+func (m *TuiModel) GetPageOffset() int {
+	if m.QueryResult != nil {
+		return 0
+	}
+
+	return m.DefaultTable.PageOffset
+}
+
+// GetRowCount gets the total number of rows in the current table, not just the ones that are loaded.
+// Until the background count finishes this is as far as the loaded rows go, plus one if there may be more
+func (m *TuiModel) GetRowCount() int {
+	loaded := pageLength(m.GetSchemaData())
+	if m.QueryResult != nil {
+		return loaded
+	}
+
+	if count, ok := m.DefaultTable.RowCounts[m.GetSchemaName()]; ok && count > -1 {
+		return count
+	}
+
+	if loaded == PageSize {
+		return m.DefaultTable.PageOffset + loaded + 1
+	}
+
+	return m.DefaultTable.PageOffset + loaded
+}
+
+// GetRowCountString is GetRowCount for display, marking counts that aren't final yet
+func (m *TuiModel) GetRowCountString() string {
+	if count, ok := m.DefaultTable.RowCounts[m.GetSchemaName()]; m.QueryResult == nil && (!ok || count < 0) {
+		return fmt.Sprintf("%d+", m.GetRowCount())
+	}
+
+	return fmt.Sprintf("%d", m.GetRowCount())
+}
+
+// CountRows returns a command that counts the rows of the current table, if that hasn't been counted yet. Other
+// tables are counted once they're looked at: a SQLite database has a single connection, so every count holds up
+// the pages read after it
+func (m *TuiModel) CountRows() tea.Cmd {
+	t := &m.DefaultTable
+	schemaName := m.GetSchemaName()
+	if t.RowCounts == nil || t.Database.GetDatabaseReference() == nil || m.QueryResult != nil || schemaName == "" {
+		return nil
+	}
+	if _, ok := t.RowCounts[schemaName]; ok {
+		return nil
+	}
+	t.RowCounts[schemaName] = -1 // in progress
+
+	var (
+		db     = t.Database.GetDatabaseReference()
+		counts = t.RowCounts
+		query  = fmt.Sprintf("select count(*) from %s%s", database.QuoteTableName(t.Database, schemaName), t.Views[schemaName].WhereClause())
+	)
+
+	return func() tea.Msg {
+		var count sql.NullInt64
+		if err := db.QueryRow(query).Scan(&count); err != nil {
+			return nil // leave it uncounted, GetRowCount falls back to the loaded rows
+		}
+
+		return RowCountMsg{
+			Counts: counts,
+			Table:  schemaName,
+			Count:  int(count.Int64),
+		}
+	}
+}
+
+func pageLength(schemaData map[string][]interface{}) int {
+	for _, v := range schemaData {
+		return len(v)
+	}
+
+	return 0
+}
@@ -0,0 +1,208 @@
+package viewer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mathaou/termdbms/database"
+	"github.com/mathaou/termdbms/list"
+)
+
+// QueryState is a statement from SQL mode, or a search through every table, running in the background. Only one
+// runs at a time, and since it holds the database connection the UI doesn't load pages or take edits until it's
+// done or cancelled
+type QueryState struct {
+	Statement string
+	Exec      bool // changes the database, so it runs as a step of the undo journal
+	DryRun    bool // only tried in a transaction that's rolled back, to count the rows it changes before asking
+	Search    bool // Statement is a term to look for in every table, see SearchAllTables
+	Started   time.Time
+	Cancel    context.CancelFunc // nil until RunQuery starts it
+	Cancelled bool
+	Spinner   spinner.Model
+}
+
+// QueryDoneMsg carries what a query read, or why it failed. Query is the state it was started for
+type QueryDoneMsg struct {
+	Query   *QueryState
+	Columns []string
+	Values  map[string][]interface{}
+	Rows    int64 // changed by a statement that isn't a query, or by a dry run
+	Hits    []list.Item
+	Err     error
+}
+
+// StartQuery queues a statement, RunQuery picks it up at the end of the update
+func (m *TuiModel) StartQuery(statement string) {
+	keyword := GetStatementKeyword(statement)
+	m.Query = &QueryState{
+		Statement: statement,
+		Exec:      keyword == "insert" || keyword == "create" || DestructiveStatements[keyword],
+		Spinner:   spinner.NewModel(),
+	}
+}
+
+// RunQuery returns a command that runs the queued statement, along with the spinner in the footer
+func (m *TuiModel) RunQuery() tea.Cmd {
+	q := m.Query
+	if q == nil || q.Cancel != nil {
+		return nil
+	}
+
+	var (
+		ctx     context.Context
+		dbs     = m.DefaultTable.Database
+		db      = m.Table().Database.GetDatabaseReference()
+		journal = m.Journal
+	)
+	ctx, q.Cancel = context.WithCancel(context.Background())
+	q.Started = time.Now()
+	if q.Search {
+		return tea.Batch(spinner.Tick, m.searchAllTables(ctx, QueryDoneMsg{Query: q}))
+	}
+
+	return tea.Batch(spinner.Tick, func() tea.Msg {
+		done := QueryDoneMsg{Query: q}
+		if q.DryRun {
+			done.Rows, done.Err = database.CountAffectedRows(ctx, dbs, q.Statement)
+			return done
+		}
+		if q.Exec {
+			exec := func() error {
+				result, err := db.ExecContext(ctx, q.Statement)
+				if err == nil {
+					done.Rows, _ = result.RowsAffected()
+				}
+				return err
+			}
+			if journal != nil {
+				done.Err = journal.Record(exec)
+			} else {
+				done.Err = exec()
+			}
+
+			return done
+		}
+
+		c, err := db.QueryContext(ctx, q.Statement)
+		if err != nil {
+			done.Err = err
+			return done
+		}
+		defer c.Close()
+		done.Columns, done.Values = ReadColumns(c)
+		done.Err = c.Err() // a cancelled query stops reading rows here
+
+		return done
+	})
+}
+
+// CancelQuery stops the running statement. It's interrupted by the driver, QueryDoneMsg still follows
+func (m *TuiModel) CancelQuery() {
+	if m.Query == nil || m.Query.Cancel == nil || m.Query.Cancelled {
+		return
+	}
+	m.Query.Cancelled = true
+	m.Query.Cancel()
+}
+
+// FinishQuery shows the results of a query, or reloads the tables after a statement changed them
+func (m *TuiModel) FinishQuery(msg QueryDoneMsg) {
+	q := msg.Query
+	if q != m.Query {
+		return
+	}
+	m.Query = nil
+	q.Cancel()
+
+	if q.Search {
+		if msg.Err != nil && q.Cancelled {
+			m.WriteMessage(fmt.Sprintf("Cancelled after %s.", q.Elapsed()))
+		} else if msg.Err != nil {
+			m.DisplayMessage(fmt.Sprintf("%v", msg.Err))
+		} else {
+			m.FinishSearch(q.Statement, msg.Hits)
+		}
+		return
+	}
+
+	if !q.DryRun { // trying a statement isn't running it
+		rows := msg.Rows
+		if !q.Exec {
+			rows = int64(pageLength(msg.Values))
+		}
+		m.RecordHistory(q, rows, msg.Err)
+	}
+	if q.Exec && !q.DryRun {
+		m.UpdateChangedCells()
+	}
+	if msg.Err != nil && q.Cancelled { // back to the statement, so it can be changed and run again
+		m.TextInput.Model.SetValue("")
+		m.WriteMessage(fmt.Sprintf("Cancelled after %s.", q.Elapsed()))
+		return
+	}
+
+	if q.DryRun && msg.Err == nil {
+		m.Confirm(getStatementConfirmation(m, q.Statement, msg.Rows), func(m *TuiModel) {
+			m.StartQuery(q.Statement)
+		})
+		return
+	}
+
+	m.QueryResult = nil
+	m.QueryData = nil
+	if msg.Err != nil {
+		ExitToDefaultView(m)
+		m.DisplayMessage(fmt.Sprintf("%v", msg.Err))
+		return
+	}
+
+	if q.Exec {
+		if err := m.SetModel(nil, m.DefaultTable.Database.GetDatabaseReference()); err != nil {
+			m.DisplayMessage(fmt.Sprintf("%v", err))
+		} else {
+			ExitToDefaultView(m)
+		}
+		return
+	}
+
+	m.QueryResult = &TableState{
+		Database: m.DefaultTable.Database,
+		Data:     make(map[string]interface{}),
+	}
+	m.QueryData = &UIData{
+		TableHeaders:      make(map[string][]string),
+		TableIndexMap:     make(map[int]string),
+		TableSlices:       make(map[string][]interface{}),
+		TableHeadersSlice: []string{},
+	}
+	i := 0
+	m.PopulateDataForResult(msg.Columns, msg.Values, &i, QueryResultsTableName)
+	ExitToDefaultView(m)
+	m.UI.CurrentTable = 1
+	m.Data().EditTextBuffer = ""
+	m.FormatInput.Model.SetValue("")
+}
+
+// Elapsed is how long the statement has been running, to a tenth of a second
+func (q *QueryState) Elapsed() time.Duration {
+	return time.Since(q.Started).Round(time.Second / 10)
+}
+
+// Status is the footer while the statement runs
+func (q *QueryState) Status() string {
+	if q.Cancelled {
+		return fmt.Sprintf(" %s cancelling... ", q.Spinner.View())
+	}
+
+	if q.DryRun {
+		return fmt.Sprintf(" %s trying %s, [ESC] to cancel ", q.Spinner.View(), q.Elapsed())
+	} else if q.Search {
+		return fmt.Sprintf(" %s searching %s, [ESC] to cancel ", q.Spinner.View(), q.Elapsed())
+	}
+
+	return fmt.Sprintf(" %s running %s, [ESC] to cancel ", q.Spinner.View(), q.Elapsed())
+}
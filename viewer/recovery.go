@@ -0,0 +1,151 @@
+package viewer
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mathaou/termdbms/database"
+)
+
+// AskRecovery offers the next crashed session of the same files: restore its working copy over this one, show
+// what it changed, discard it, or leave it for the next start. Sessions without changes are discarded quietly
+func (m *TuiModel) AskRecovery() {
+	for len(m.Recovery) > 0 {
+		s := m.Recovery[0]
+		changes, err := getSessionChanges(s)
+		if err != nil { // it stays for the next start, or to be recovered by hand
+			m.Recovery = m.Recovery[1:]
+			m.WriteMessage(fmt.Sprintf("Could not read the working copy in %s: %v", s.Dir, err))
+			continue
+		}
+		if len(changes) == 0 {
+			m.Recovery = m.Recovery[1:]
+			os.RemoveAll(s.Dir)
+			continue
+		}
+		m.askRecovery(s, changes, false)
+		return
+	}
+}
+
+func (m *TuiModel) askRecovery(s CrashedSession, changes []database.Change, detail bool) {
+	originals := strings.Join(s.Manifest.Originals, ", ")
+	question := fmt.Sprintf("A session editing %s (started %s) didn't exit cleanly and left %d unsaved change(s).",
+		originals, s.Manifest.Started.Format("2006-01-02 15:04"), len(changes))
+	for _, o := range s.Manifest.Originals {
+		if info, err := os.Stat(o); err == nil && info.ModTime().After(s.Manifest.Started) {
+			question += fmt.Sprintf(" %s was changed since.", o)
+		}
+	}
+	if detail {
+		var b bytes.Buffer
+		WriteChanges(&b, originals, "unsaved changes", changes)
+		lines := SplitLines(strings.TrimSpace(b.String()))
+		if max := Max(m.Viewport.Height-12, 3); len(lines) > max {
+			lines = append(lines[:max], fmt.Sprintf("... %d more line(s)", len(lines)-max))
+		}
+		question += "\n\n" + strings.Join(lines, "\n")
+	}
+
+	next := func(m *TuiModel) {
+		m.Recovery = m.Recovery[1:]
+		m.AskRecovery()
+	}
+	m.Ask(question,
+		Choice{Key: "r", Label: "restore", Action: func(m *TuiModel) {
+			if err := m.RestoreSession(s); err != nil {
+				m.DisplayMessage(fmt.Sprintf("%v", err))
+				return
+			}
+			m.WriteMessage(fmt.Sprintf("Restored %d unsaved change(s).", len(changes)))
+			next(m)
+		}},
+		Choice{Key: "d", Label: "show changes", Action: func(m *TuiModel) {
+			m.askRecovery(s, changes, !detail)
+		}},
+		Choice{Key: "x", Label: "discard", Action: func(m *TuiModel) {
+			os.RemoveAll(s.Dir)
+			next(m)
+		}},
+		Choice{Key: "esc", Label: "later", Action: next},
+	)
+}
+
+// RestoreSession puts the working copy of a crashed session in place of this one. Its changes become pending
+// changes, but there's no undoing them one by one
+func (m *TuiModel) RestoreSession(s CrashedSession) error {
+	db, ok := m.DefaultTable.Database.(*database.SQLite)
+	if !ok {
+		return serializationError()
+	}
+	workingCopy := db.GetFileName()
+
+	db.CloseDatabaseReference()
+	for _, suffix := range []string{"", "-journal", "-wal"} { // a rollback journal left by the crash comes along
+		src := s.Manifest.WorkingCopy + suffix
+		if exists, _ := Exists(src); !exists {
+			os.Remove(workingCopy + suffix)
+			continue
+		}
+		if err := copyFileTo(src, workingCopy+suffix); err != nil {
+			db.SetDatabaseReference(workingCopy)
+			return err
+		}
+	}
+	if err := db.SetDatabaseReference(workingCopy); err != nil {
+		return err
+	}
+
+	m.QueryResult = nil
+	m.QueryData = nil
+	m.Journal = nil // undo history starts over from the restored copy
+	m.DefaultTable.RowCounts = make(map[string]int)
+	m.InvalidatePage()
+	if err := m.SetModel(nil, db.GetDatabaseReference()); err != nil {
+		return err
+	}
+	m.Changes.Restored = true
+	m.Changes.Saved = 0 // the journal of the new connection starts from scratch
+	m.UpdateChangedCells()
+	os.RemoveAll(s.Dir)
+
+	return nil
+}
+
+// getSessionChanges compares the working copy of a crashed session with the file it was made from
+func getSessionChanges(s CrashedSession) ([]database.Change, error) {
+	if exists, _ := Exists(s.Manifest.WorkingCopy); !exists {
+		return nil, fmt.Errorf("%s is missing", filepath.Base(s.Manifest.WorkingCopy))
+	}
+	db, err := sql.Open(database.DriverSQLite, s.Manifest.WorkingCopy)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1) // the base is attached to the connection
+
+	return database.DiffSQLite(db, s.Manifest.Base, nil)
+}
+
+func copyFileTo(src, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	destination, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(destination, source); err != nil {
+		destination.Close()
+		return err
+	}
+
+	return destination.Close()
+}
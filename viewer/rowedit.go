@@ -0,0 +1,198 @@
+package viewer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mathaou/termdbms/database"
+)
+
+const (
+	insertFormComment = "--"
+	insertFormDivider = " = "
+	insertFormNull    = "NULL"
+)
+
+// OpenInsertForm opens a format mode buffer with one line per column of the current table. values pre-fills
+// the form (i.e. when duplicating a row), otherwise plain literal defaults are filled in
+func OpenInsertForm(m *TuiModel, values map[string]interface{}) {
+	columns, err := m.Table().Database.GetColumns(m.GetSchemaName())
+	if err != nil {
+		m.DisplayMessage(fmt.Sprintf("%v", err))
+		return
+	}
+
+	CreatePopulatedBuffer(m, nil, GetInsertFormText(m.GetSchemaName(), columns, values))
+	m.UI.InsertForm = true
+}
+
+// GetInsertFormText lays out the form, each column preceded by a comment with its type, default and NOT NULL hints
+func GetInsertFormText(tableName string, columns []database.Column, values map[string]interface{}) string {
+	var builder strings.Builder
+
+	builder.WriteString(fmt.Sprintf("%s New row for %s. Leave a value empty to use the column default, %s for null "+
+		"and '%s' for the text %s.\n", insertFormComment, tableName, insertFormNull, insertFormNull, insertFormNull))
+	builder.WriteString(fmt.Sprintf("%s [:exec] to insert, [:q] to cancel\n", insertFormComment))
+	for _, c := range columns {
+		var hints []string
+		if c.Type != "" {
+			hints = append(hints, c.Type)
+		}
+		if c.PrimaryKey {
+			hints = append(hints, "primary key")
+		}
+		if c.NotNull {
+			hints = append(hints, "NOT NULL")
+		}
+		if c.Default.Valid {
+			hints = append(hints, "default "+c.Default.String)
+		}
+
+		value := ""
+		if values != nil {
+			if v, ok := values[c.Name]; ok && !c.PrimaryKey { // duplicates get a new key
+				value = GetStringRepresentationOfInterface(v)
+				if v != nil && isInsertFormNull(value) { // text that would be read back as NULL
+					value = "'" + value + "'"
+				}
+			}
+		} else if c.Default.Valid {
+			value = getLiteralDefault(c.Default.String)
+		}
+
+		builder.WriteString(fmt.Sprintf("%s %s %s\n", insertFormComment, c.Name, strings.Join(hints, ", ")))
+		builder.WriteString(c.Name + insertFormDivider + value + "\n")
+	}
+
+	return builder.String()
+}
+
+// ParseInsertForm reads the column values back out of the form, skipping columns that were left empty. NULL is
+// null, and a NULL in quotes loses a pair of them, so 'NULL' is the text NULL
+func ParseInsertForm(text string, headers []string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+
+	for n, line := range SplitLines(text) {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, insertFormComment) {
+			continue
+		}
+
+		column, value, err := splitInsertFormLine(strings.TrimLeft(line, " \t"), headers)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+
+		if strings.TrimSpace(value) == "" {
+			continue
+		} else if value == insertFormNull {
+			values[column] = nil
+		} else if isInsertFormNull(value) {
+			values[column] = value[1 : len(value)-1]
+		} else {
+			values[column] = value
+		}
+	}
+
+	return values, nil
+}
+
+// splitInsertFormLine splits a line of the form at the divider after its column, so neither the value nor the
+// column name (which may have an = of its own) gets cut short. Editors that drop trailing spaces leave an empty
+// value without the space after the =
+func splitInsertFormLine(line string, headers []string) (string, string, error) {
+	column, value, found := "", "", false
+	for _, h := range headers {
+		if len(h) < len(column) {
+			continue
+		}
+		if strings.HasPrefix(line, h+insertFormDivider) {
+			column, value, found = h, line[len(h+insertFormDivider):], true
+		} else if strings.TrimRight(line, " ") == h+strings.TrimRight(insertFormDivider, " ") {
+			column, value, found = h, "", true
+		}
+	}
+	if found {
+		return column, value, nil
+	}
+
+	i := strings.Index(line, strings.TrimSpace(insertFormDivider))
+	if i < 0 {
+		return "", "", fmt.Errorf("expected <column>%s<value>", insertFormDivider)
+	}
+
+	return "", "", fmt.Errorf("unknown column %s", strings.TrimSpace(line[:i]))
+}
+
+// isInsertFormNull is true for NULL with any number of pairs of quotes around it
+func isInsertFormNull(value string) bool {
+	for len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		value = value[1 : len(value)-1]
+	}
+
+	return value == insertFormNull
+}
+
+func handleInsertForm(m *TuiModel, input string) {
+	t := m.Table()
+	schemaName := m.GetSchemaName()
+
+	values, err := ParseInsertForm(input, m.GetHeaders())
+	if err != nil {
+		m.TextInput.Model.SetValue("")
+		m.WriteMessage(fmt.Sprintf("%v", err))
+		return
+	}
+
+	err = m.RecordEdit(func() error {
+		return database.ProcessSqlQueryForDatabaseType(&database.Insert{}, values, schemaName, "", &t.Database)
+	})
+	if err != nil { // the form stays open to fix the values
+		m.TextInput.Model.SetValue("")
+		m.WriteMessage(fmt.Sprintf("Could not insert into %s: %v", schemaName, err))
+		return
+	}
+
+	ExitToDefaultView(m)
+	m.Data().EditTextBuffer = ""
+	m.InvalidatePage()
+	m.DefaultTable.RowCounts = make(map[string]int)
+	m.WriteMessage(fmt.Sprintf("Inserted row into %s.", schemaName))
+}
+
+// DeleteSelectedRow deletes the row under the cursor
+func DeleteSelectedRow(m *TuiModel) {
+	t := m.Table()
+	schemaName := m.GetSchemaName()
+	rowData := m.GetRowData()
+
+	err := m.RecordEdit(func() error {
+		return database.ProcessSqlQueryForDatabaseType(&database.Delete{}, rowData, schemaName, "", &t.Database)
+	})
+
+	ExitToDefaultView(m)
+	if err != nil {
+		m.DisplayMessage(fmt.Sprintf("Could not delete from %s:\n%v", schemaName, err))
+		return
+	}
+	m.InvalidatePage()
+	m.DefaultTable.RowCounts = make(map[string]int)
+	if key := t.Database.GetTableKey(schemaName); key.Warning != "" {
+		m.WriteMessage(key.Warning)
+	} else {
+		m.WriteMessage(fmt.Sprintf("Deleted row from %s.", schemaName))
+	}
+}
+
+// getLiteralDefault returns a declared default as a form value if it is a plain number or string, expressions
+// like CURRENT_TIMESTAMP are left for the database to fill in
+func getLiteralDefault(d string) string {
+	if _, err := strconv.ParseFloat(d, 64); err == nil {
+		return d
+	}
+	if len(d) >= 2 && strings.HasPrefix(d, "'") && strings.HasSuffix(d, "'") {
+		return strings.ReplaceAll(d[1:len(d)-1], "''", "'")
+	}
+
+	return ""
+}
@@ -0,0 +1,152 @@
+package viewer
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mathaou/termdbms/database"
+	"github.com/mathaou/termdbms/list"
+)
+
+// SchemaItem is a table, view, index or trigger in the schema browser
+type SchemaItem struct {
+	database.SchemaObject
+}
+
+func (s SchemaItem) Title() string {
+	if s.Table != s.Name {
+		return fmt.Sprintf("%s %s on %s", s.Type, s.Name, s.Table)
+	}
+
+	return fmt.Sprintf("%s %s", s.Type, s.Name)
+}
+
+func (s SchemaItem) Description() string {
+	return strings.Join(strings.Fields(s.SQL), " ")
+}
+
+func (s SchemaItem) FilterValue() string {
+	return s.Title()
+}
+
+// OpenSchemaBrowser lists everything in the schema of the database
+func OpenSchemaBrowser(m *TuiModel) error {
+	objects, err := m.DefaultTable.Database.GetSchemaObjects()
+	if err != nil {
+		return err
+	}
+
+	items := make([]list.Item, len(objects))
+	for i, o := range objects {
+		items[i] = SchemaItem{o}
+	}
+
+	m.SchemaList = list.NewModel(items, list.NewDefaultDelegate(), TUIWidth, TUIHeight)
+	m.SchemaList.Title = fmt.Sprintf("Schema of %s", m.InitialFileName)
+	m.SchemaList.SetFilteringEnabled(true)
+	m.SchemaList.SetShowPagination(true)
+	m.SchemaList.SetShowTitle(true)
+	m.UI.ShowSchema = true
+
+	return nil
+}
+
+func ShowSchema(m *TuiModel) string {
+	return m.SchemaList.View()
+}
+
+func HandleSchemaEvents(m *TuiModel, str string, command *tea.Cmd, msg tea.Msg) {
+	if (str == "q" || str == "esc" || str == "enter") && m.SchemaList.FilterState() != list.Filtering {
+		m.UI.ShowSchema = false
+		if item, ok := m.SchemaList.SelectedItem().(SchemaItem); ok && str == "enter" {
+			text, err := GetSchemaObjectText(m, item.SchemaObject)
+			if err != nil {
+				text = fmt.Sprintf("%s\n\n%v", text, err)
+			}
+			m.DisplayMessage(text)
+			m.UI.SchemaDetail = true // esc goes back to the list
+			return
+		}
+		m.SchemaList.ResetFilter()
+	} else {
+		m.SchemaList, *command = m.SchemaList.Update(msg)
+	}
+}
+
+// GetSchemaObjectText describes a schema object for the full screen view. Tables and views get their
+// columns, foreign keys and indexes listed under the CREATE statement
+func GetSchemaObjectText(m *TuiModel, o database.SchemaObject) (string, error) {
+	var b strings.Builder
+
+	db := m.DefaultTable.Database
+	b.WriteString(SchemaItem{o}.Title() + "\n\n")
+	b.WriteString(o.SQL + "\n")
+	if o.Type != "table" && o.Type != "view" {
+		return b.String(), nil
+	}
+
+	columns, err := db.GetColumns(o.Name)
+	if err != nil {
+		return b.String(), err
+	}
+	b.WriteString("\nColumns:\n")
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	for _, c := range columns {
+		var flags []string
+		if c.PrimaryKey {
+			flags = append(flags, "PK")
+		}
+		if c.NotNull {
+			flags = append(flags, "NOT NULL")
+		}
+		if c.Default.Valid {
+			flags = append(flags, "DEFAULT "+c.Default.String)
+		}
+		fmt.Fprintf(w, "    %s\t%s\t%s\n", c.Name, c.Type, strings.Join(flags, " "))
+	}
+	w.Flush()
+
+	if o.Type == "view" {
+		return b.String(), nil
+	}
+
+	keys, err := db.GetForeignKeys(o.Name)
+	if err != nil {
+		return b.String(), err
+	}
+	if len(keys) > 0 {
+		b.WriteString("\nForeign keys:\n")
+		for _, k := range keys {
+			b.WriteString(fmt.Sprintf("    (%s) -> %s(%s) ON UPDATE %s ON DELETE %s\n", strings.Join(k.Columns, ", "),
+				k.Table, strings.Join(k.References, ", "), k.OnUpdate, k.OnDelete))
+		}
+	}
+
+	indexes, err := db.GetIndexes(o.Name)
+	if err != nil {
+		return b.String(), err
+	}
+	if len(indexes) > 0 {
+		b.WriteString("\nIndexes:\n")
+		for _, i := range indexes {
+			unique := ""
+			if i.Unique {
+				unique = "UNIQUE "
+			}
+			b.WriteString(fmt.Sprintf("    %s%s (%s)\n", unique, i.Name, strings.Join(i.Columns, ", ")))
+		}
+	}
+
+	return b.String(), nil
+}
+
+// IsReadOnlyTable is true when the current table is a view
+func (m *TuiModel) IsReadOnlyTable() bool {
+	if m.QueryResult != nil {
+		return false
+	}
+
+	return m.DefaultData.ReadOnly[m.GetSchemaName()]
+}
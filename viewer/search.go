@@ -0,0 +1,465 @@
+package viewer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mathaou/termdbms/database"
+	"github.com/mathaou/termdbms/list"
+	"github.com/mathaou/termdbms/tuiutil"
+)
+
+const (
+	SearchPrompt       = "/ "
+	GlobalSearchPrompt = "// "
+	SearchChunkSize    = 4096 // rows read per query while scanning a table
+	MaxSearchHits      = 1000 // a search through every table stops after this many hits
+)
+
+// SearchHit is a cell found by a search through every table
+type SearchHit struct {
+	Table  string
+	Row    int
+	Column string
+	Value  string
+	Key    []interface{} // of the row, when it was read in key order
+}
+
+func (h SearchHit) Title() string {
+	return fmt.Sprintf("%s > %s: %s", h.Table, h.Column, strings.ReplaceAll(h.Value, "\n", " "))
+}
+
+func (h SearchHit) Description() string {
+	return fmt.Sprintf("row %d", h.Row+1)
+}
+
+func (h SearchHit) FilterValue() string {
+	return h.Title()
+}
+
+// OpenSearchPrompt switches to edit mode to take a search term for the current table, or every table if all is set
+func OpenSearchPrompt(m *TuiModel, all bool) {
+	m.UI.EditModeEnabled = true
+	m.UI.SearchPrompt = true
+	m.UI.SearchAll = all
+	m.TextInput.Model.Prompt = SearchPrompt
+	if all {
+		m.TextInput.Model.Prompt = GlobalSearchPrompt
+		m.TextInput.Model.SetValue("")
+	} else {
+		m.TextInput.Model.SetValue(m.Search.Term)
+	}
+}
+
+// closeSearchPrompt leaves edit mode without moving the selection, unlike ExitToDefaultView
+func closeSearchPrompt(m *TuiModel) {
+	m.UI.EditModeEnabled = false
+	m.UI.SearchPrompt = false
+	m.UI.SearchAll = false
+	m.TextInput.Model.Prompt = tuiutil.DefaultPrompt
+	m.TextInput.Model.Reset()
+}
+
+// UpdateSearchTerm highlights matches as the search term is typed
+func (m *TuiModel) UpdateSearchTerm() {
+	if m.UI.SearchPrompt && !m.UI.SearchAll {
+		m.Search.Term = m.TextInput.Model.Value()
+	}
+}
+
+// IsSearchMatch is true for cell text that contains the search term
+func (m *TuiModel) IsSearchMatch(s string) bool {
+	if m.Search.Term == "" || m.QueryResult != nil {
+		return false
+	}
+
+	return strings.Contains(strings.ToLower(s), strings.ToLower(m.Search.Term))
+}
+
+func handleSearch(m *TuiModel, term string) {
+	all := m.UI.SearchAll
+	closeSearchPrompt(m)
+	if term == "" {
+		m.Search.Term = ""
+		return
+	}
+
+	m.Search.Term = term
+	if all {
+		m.SearchAllTables(term)
+		return
+	}
+
+	if err := m.SearchNext(true, true); err != nil {
+		m.DisplayMessage(fmt.Sprintf("%v", err))
+	}
+}
+
+// ScanTable reads rows [from, to) of a table in the order it's shown, calling found for every cell that
+// contains term until it returns false. A negative to scans to the end of the table
+func (m *TuiModel) ScanTable(tableName, term string, from, to int, found func(row int, column, value string) bool) error {
+	t := &m.DefaultTable
+	headers := m.DefaultData.TableHeaders[tableName]
+	needle := strings.ToLower(term)
+
+	for offset := from; to < 0 || offset < to; offset += SearchChunkSize {
+		limit := SearchChunkSize
+		if to >= 0 {
+			limit = Min(limit, to-offset)
+		}
+		c, err := t.Database.GetDatabaseReference().Query(GetPageQuery(t.Database, tableName, t.Views[tableName], offset, limit))
+		if err != nil {
+			return err
+		}
+		_, columnValues := ReadColumns(c)
+		c.Close() // sqlite only gets one connection, close before anything else runs
+
+		rows := pageLength(columnValues)
+		for i := 0; i < rows; i++ {
+			for _, column := range headers {
+				value := GetStringRepresentationOfInterface(columnValues[column][i])
+				if strings.Contains(strings.ToLower(value), needle) && !found(offset+i, column, value) {
+					return nil
+				}
+			}
+		}
+		if rows < limit {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// SearchNext selects the next (or previous) cell of the current table containing the search term,
+// wrapping around at either end. includeCurrent lets the selected cell count as the next match
+func (m *TuiModel) SearchNext(forward, includeCurrent bool) error {
+	var (
+		name     = m.GetSchemaName()
+		headers  = m.GetHeaders()
+		row, col = m.GetSelectedCell()
+		current  = indexOf(headers, col)
+		hitRow   = -1
+		hitCol   string
+		err      error
+	)
+
+	// cells are ordered by row, then by column
+	after := func(r int, c string) bool {
+		i := indexOf(headers, c)
+		return r > row || (r == row && (i > current || (includeCurrent && i == current)))
+	}
+	first := func(cond func(int, string) bool) func(int, string, string) bool {
+		return func(r int, c, _ string) bool {
+			if cond(r, c) {
+				hitRow, hitCol = r, c
+				return false
+			}
+			return true
+		}
+	}
+	last := func(cond func(int, string) bool) func(int, string, string) bool {
+		return func(r int, c, _ string) bool {
+			if cond(r, c) {
+				hitRow, hitCol = r, c
+			}
+			return true
+		}
+	}
+	anywhere := func(int, string) bool { return true }
+
+	if forward {
+		err = m.ScanTable(name, m.Search.Term, row, -1, first(after))
+		if err == nil && hitRow < 0 { // wrap around to the top
+			err = m.ScanTable(name, m.Search.Term, 0, row+1, first(anywhere))
+		}
+	} else {
+		err = m.ScanTable(name, m.Search.Term, 0, row+1, last(func(r int, c string) bool {
+			i := indexOf(headers, c)
+			return r < row || (r == row && (i < current || (includeCurrent && i == current)))
+		}))
+		if err == nil && hitRow < 0 { // wrap around to the bottom
+			err = m.ScanTable(name, m.Search.Term, row, -1, last(anywhere))
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if hitRow < 0 {
+		m.WriteMessage(fmt.Sprintf("No matches for %s in %s.", m.Search.Term, name))
+		return nil
+	}
+	m.JumpToCell(hitRow, hitCol)
+
+	return nil
+}
+
+// SearchAllTables queues a search through every table. It runs in the background like a statement from SQL mode,
+// see RunQuery, and its hits come back in QueryDoneMsg
+func (m *TuiModel) SearchAllTables(term string) {
+	m.Query = &QueryState{
+		Statement: term,
+		Search:    true,
+		Spinner:   spinner.NewModel(),
+	}
+}
+
+// searchAllTables returns what RunQuery runs for a search through every table. Everything it needs from the model
+// is copied first, the model keeps changing while it runs
+func (m *TuiModel) searchAllTables(ctx context.Context, done QueryDoneMsg) func() tea.Msg {
+	var (
+		db      = m.DefaultTable.Database
+		term    = done.Query.Statement
+		tables  []string
+		views   = map[string]TableView{}
+		headers = map[string][]string{}
+	)
+	d := &m.DefaultData
+	for i := 1; i <= len(d.TableIndexMap); i++ {
+		table := d.TableIndexMap[i]
+		tables = append(tables, table)
+		views[table] = m.DefaultTable.Views[table]
+		headers[table] = d.TableHeaders[table]
+	}
+
+	return func() tea.Msg {
+		for _, table := range tables {
+			var hits []SearchHit
+			err := SearchTable(ctx, db, table, views[table], headers[table], term, func(hit SearchHit) bool {
+				hits = append(hits, hit)
+				return len(done.Hits)+len(hits) < MaxSearchHits
+			})
+			if err == nil && len(hits) > 0 && hits[0].Key != nil && views[table].SortColumn != "" {
+				err = numberSortedHits(ctx, db, table, views[table], hits)
+			}
+			if err != nil {
+				done.Err = err
+				return done
+			}
+			for _, hit := range hits {
+				done.Hits = append(done.Hits, hit)
+			}
+			if len(done.Hits) == MaxSearchHits {
+				break
+			}
+		}
+
+		return done
+	}
+}
+
+// FinishSearch shows where the search through every table found its term as a list
+func (m *TuiModel) FinishSearch(term string, hits []list.Item) {
+	if len(hits) == 0 {
+		m.WriteMessage(fmt.Sprintf("No matches for %s.", term))
+		return
+	}
+
+	m.Search.Results = list.NewModel(hits, list.NewDefaultDelegate(), TUIWidth, TUIHeight)
+	m.Search.Results.Title = fmt.Sprintf("%d match(es) for %s", len(hits), term)
+	if len(hits) == MaxSearchHits {
+		m.Search.Results.Title = fmt.Sprintf("First %d matches for %s", MaxSearchHits, term)
+	}
+	m.Search.Results.SetFilteringEnabled(true)
+	m.Search.Results.SetShowPagination(true)
+	m.Search.Results.SetShowTitle(true)
+	m.UI.ShowSearchResults = true
+}
+
+// SearchTable calls found for every cell of a table containing term, until it returns false. Rows are read
+// SearchChunkSize at a time in key (or row id) order, each chunk starting after the key of the last row, so the
+// last chunk of a big table costs no more than the first. Rows are numbered in that order and hits keep their
+// key, a sorted table shows them elsewhere, see numberSortedHits. Tables without either, like views, are read
+// in the order they're shown by offset instead
+func SearchTable(ctx context.Context, db database.Database, tableName string, view TableView, headers []string, term string, found func(hit SearchHit) bool) error {
+	key := db.GetTableKey(tableName)
+	keyColumns, keyTerms := getSearchKey(db, key)
+	needle := strings.ToLower(term)
+
+	var (
+		last []interface{} // key of the last row read
+		row  int
+	)
+	for {
+		query := GetPageQuery(db, tableName, view, row, SearchChunkSize)
+		var args []interface{}
+		if len(keyColumns) > 0 {
+			query, args = getKeysetQuery(db, tableName, key, view, keyTerms, last)
+		}
+		c, err := db.GetDatabaseReference().QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		_, columnValues := ReadColumns(c)
+		err = c.Err()
+		c.Close() // sqlite only gets one connection, close before anything else runs
+		if err != nil {
+			return err
+		}
+
+		rows := pageLength(columnValues)
+		for i := 0; i < rows; i++ {
+			if len(keyColumns) > 0 {
+				last = make([]interface{}, len(keyColumns))
+				for k, column := range keyColumns {
+					last[k] = columnValues[column][i]
+				}
+			}
+			for _, column := range headers {
+				value := GetStringRepresentationOfInterface(columnValues[column][i])
+				if !strings.Contains(strings.ToLower(value), needle) {
+					continue
+				}
+				if !found(SearchHit{Table: tableName, Row: row, Column: column, Value: value, Key: last}) {
+					return nil
+				}
+			}
+			row++
+		}
+		if rows < SearchChunkSize {
+			return nil
+		}
+	}
+}
+
+// getSearchKey is the columns a table's rows come back with that make up its key, and the terms to order by them
+func getSearchKey(db database.Database, key database.TableKey) ([]string, []string) {
+	if key.RowID != "" {
+		return []string{database.RowIDColumn}, []string{key.RowID}
+	}
+
+	terms := make([]string, len(key.Columns))
+	for i, column := range key.Columns {
+		terms[i] = db.QuoteIdentifier(column)
+	}
+
+	return key.Columns, terms
+}
+
+// getKeysetQuery reads a chunk of rows in key order, after the row with key last if that's set
+func getKeysetQuery(db database.Database, tableName string, key database.TableKey, view TableView, keyTerms []string, last []interface{}) (string, []interface{}) {
+	var query strings.Builder
+	query.WriteString("select ")
+	if key.RowID != "" {
+		query.WriteString(fmt.Sprintf("%s as %s, ", key.RowID, database.RowIDColumn))
+	}
+	query.WriteString("* from " + database.QuoteTableName(db, tableName))
+	where := view.WhereClause()
+	if last != nil {
+		placeholders := make([]string, len(last))
+		for i := range last {
+			placeholders[i] = db.GetPlaceholderForDatabaseType(i + 1)
+		}
+		condition := fmt.Sprintf("(%s) > (%s)", strings.Join(keyTerms, ", "), strings.Join(placeholders, ", "))
+		if where == "" {
+			where = " where " + condition
+		} else {
+			where += " and " + condition
+		}
+	}
+	query.WriteString(where)
+	query.WriteString(fmt.Sprintf(" order by %s limit %d", strings.Join(keyTerms, ", "), SearchChunkSize))
+
+	return query.String(), last
+}
+
+// numberSortedHits moves the row numbers of hits from key order to the order of a sorted table. It reads the key
+// of every row the filter lets through in that order once, rather than looking up each hit
+func numberSortedHits(ctx context.Context, db database.Database, tableName string, view TableView, hits []SearchHit) error {
+	key := db.GetTableKey(tableName)
+	_, keyTerms := getSearchKey(db, key)
+
+	rows := map[string][]int{} // hits by the key of their row
+	for i, hit := range hits {
+		k := database.QuoteKey(hit.Key)
+		rows[k] = append(rows[k], i)
+	}
+
+	query := fmt.Sprintf("select %s from %s%s order by %s", strings.Join(keyTerms, ", "),
+		database.QuoteTableName(db, tableName), view.WhereClause(), strings.Join(view.OrderTerms(db, keyTerms), ", "))
+	c, err := db.GetDatabaseReference().QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	values := make([]interface{}, len(keyTerms))
+	pointers := make([]interface{}, len(keyTerms))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for row := 0; c.Next() && len(rows) > 0; row++ {
+		if err = c.Scan(pointers...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok { // the same as ReadColumns
+				values[i] = string(b)
+			}
+		}
+		k := database.QuoteKey(values)
+		for _, i := range rows[k] {
+			hits[i].Row = row
+		}
+		delete(rows, k)
+	}
+
+	return c.Err()
+}
+
+func ShowSearchResults(m *TuiModel) string {
+	return m.Search.Results.View()
+}
+
+func HandleSearchResultEvents(m *TuiModel, str string, command *tea.Cmd, msg tea.Msg) {
+	if (str == "q" || str == "esc" || str == "enter") && m.Search.Results.FilterState() != list.Filtering {
+		m.UI.ShowSearchResults = false
+		if hit, ok := m.Search.Results.SelectedItem().(SearchHit); ok && str == "enter" {
+			for i, table := range m.DefaultData.TableIndexMap {
+				if table == hit.Table {
+					m.UI.CurrentTable = i
+					m.TableStyle = m.TableStyle.Width(m.CellWidth())
+					break
+				}
+			}
+			m.JumpToCell(hit.Row, hit.Column)
+		}
+		m.Search.Results.ResetFilter()
+	} else {
+		m.Search.Results, *command = m.Search.Results.Update(msg)
+	}
+}
+
+// GetSelectedCell gets the absolute row and the column of the selected cell in the table view
+func (m *TuiModel) GetSelectedCell() (int, string) {
+	return Max(m.MouseData.Y-HeaderHeight, 0) + m.Viewport.YOffset, m.GetHeaderAt(m.MouseData.X)
+}
+
+// JumpToCell scrolls the current table so the cell at row and column is on screen and selects it
+func (m *TuiModel) JumpToCell(row int, column string) {
+	headers := m.GetHeaders()
+	index := Max(indexOf(headers, column), 0)
+
+	m.UI.ExpandColumn = -1
+	if len(headers) > maxHeaders { // only maxHeaders-1 columns fit, see SetViewSlices
+		visible := maxHeaders - 1
+		if index < m.Scroll.ScrollXOffset || index >= m.Scroll.ScrollXOffset+visible {
+			m.Scroll.ScrollXOffset = Min(index, len(headers)-visible)
+		}
+		index -= m.Scroll.ScrollXOffset
+	} else {
+		m.Scroll.ScrollXOffset = 0
+	}
+	m.MouseData.X = index * m.CellWidth()
+
+	if row < m.Viewport.YOffset || row >= m.Viewport.YOffset+m.Viewport.Height {
+		m.Viewport.YOffset = Max(row-m.Viewport.Height/2, 0)
+	}
+	m.MouseData.Y = HeaderHeight + row - m.Viewport.YOffset
+	m.Scroll.PreScrollYOffset = m.Viewport.YOffset
+	m.Scroll.PreScrollYPosition = m.MouseData.Y
+}
@@ -1,71 +1,152 @@
 package viewer
 
 import (
-	"errors"
 	"fmt"
-	"log"
-	"math/rand"
 	"os"
-	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/mathaou/termdbms/database"
+	"github.com/mathaou/termdbms/tuiutil"
 )
 
-var (
-	serializationErrorString = fmt.Sprintf("Database driver %s does not support serialization.", database.DriverString)
-)
+func serializationError() error {
+	return fmt.Errorf("Database driver %s does not support serialization.", database.DriverString)
+}
 
-func Serialize(m *TuiModel) (string, error) {
-	switch m.Table().Database.(type) {
+// Serialize saves a copy of the database to fileName, or next to the original file if it's empty
+func Serialize(m *TuiModel, fileName string) (string, error) {
+	if database.IsCSV {
+		return SerializeCSVFiles(m, false, fileName)
+	}
+	switch db := m.Table().Database.(type) {
 	case *database.SQLite:
-		return SerializeSQLiteDB(m.Table().Database.(*database.SQLite), m), nil
+		if fileName == "" {
+			fileName = getCopyFileName(m.InitialFileName)
+		}
+		return fileName, SaveSQLiteCopy(db, fileName)
 	default:
-		return "", errors.New(serializationErrorString)
+		return "", serializationError()
 	}
 }
 
+// SerializeOverwrite writes the changes over the original file, after which nothing is pending
 func SerializeOverwrite(m *TuiModel) error {
-	t := m.Table()
-	switch t.Database.(type) {
-	case *database.SQLite:
-		SerializeOverwriteSQLiteDB(t.Database.(*database.SQLite), m)
-		return nil
-	default:
-		return errors.New(serializationErrorString)
+	db, ok := m.DefaultTable.Database.(*database.SQLite)
+	if !ok {
+		return serializationError()
+	}
+
+	var err error
+	if database.IsCSV {
+		if _, err = SerializeCSVFiles(m, true, ""); err == nil && m.BaseFileName != "" {
+			err = SaveSQLiteCopy(db, m.BaseFileName) // the import the changes are compared with
+		}
+	} else {
+		err = SaveSQLiteCopy(db, m.InitialFileName)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the original file has every change now, only later edits get highlighted
+	if m.Journal != nil {
+		if m.Changes.Saved, err = m.Journal.GetLastSequence(); err != nil {
+			return err
+		}
+	}
+	m.UpdateChangedCells()
+
+	return nil
+}
+
+// getCopyFileName is name-1.ext, or name-2.ext and so on if that already exists
+func getCopyFileName(fileName string) string {
+	ext := filepath.Ext(fileName)
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("%s-%d%s", strings.TrimSuffix(fileName, ext), n, ext)
+		if exists, _ := Exists(name); !exists {
+			return name
+		}
 	}
 }
 
 // SQLITE
 
-func SerializeSQLiteDB(db *database.SQLite, m *TuiModel) string {
-	db.CloseDatabaseReference()
-	source, err := os.ReadFile(db.GetFileName())
-	if err != nil {
-		panic(err)
+// SaveSQLiteCopy writes a consistent, compacted copy of the database to fileName with VACUUM INTO, creating
+// directories as needed. An existing file is only replaced once the copy is complete, with the same permissions.
+// It's a new file though, hard links to the old one keep the old contents
+func SaveSQLiteCopy(db *database.SQLite, fileName string) error {
+	dir := filepath.Dir(fileName)
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return err
 	}
-	ext := path.Ext(m.InitialFileName)
-	newFileName := fmt.Sprintf("%s-%d%s", strings.TrimSuffix(m.InitialFileName, ext), rand.Intn(4), ext)
-	err = os.WriteFile(newFileName, source, 0777)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fileName)+"-*")
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	tmp.Close()
+	os.Remove(tmp.Name()) // VACUUM INTO won't write over a file
+
+	if _, err = db.GetDatabaseReference().Exec("VACUUM INTO ?", tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), GetFileMode(fileName, 0o644)); err != nil { // a replaced file keeps its permissions
+		os.Remove(tmp.Name())
+		return err
 	}
-	db.SetDatabaseReference(db.GetFileName())
-	return newFileName
+	if err = os.Rename(tmp.Name(), fileName); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
 }
 
-func SerializeOverwriteSQLiteDB(db *database.SQLite, m *TuiModel) {
-	db.CloseDatabaseReference()
-	filename := db.GetFileName()
+// CSV
 
-	source, err := os.ReadFile(filename)
-	if err != nil {
-		panic(err)
+// SerializeCSVFiles writes every imported table back to a csv (or json) file, either over the file it came from or
+// next to it. A target is the file to write to if there's one table, or the directory to write them all to. The
+// tables are written whole, without the sort and filter of the view
+func SerializeCSVFiles(m *TuiModel, overwrite bool, target string) (string, error) {
+	var written []string
+	db := m.DefaultTable.Database
+	if target != "" {
+		dir := target
+		if len(database.CSVFiles) == 1 {
+			dir = filepath.Dir(target)
+		}
+		if err := os.MkdirAll(dir, 0o777); err != nil {
+			return "", err
+		}
 	}
-
-	err = os.WriteFile(m.InitialFileName, source, 0777)
-	if err != nil {
-		log.Fatal(err)
+	for table, fileName := range database.CSVFiles {
+		if target != "" && len(database.CSVFiles) == 1 {
+			fileName = target
+		} else if target != "" {
+			fileName = filepath.Join(target, filepath.Base(fileName))
+		} else if !overwrite {
+			fileName = getCopyFileName(fileName)
+		}
+		var err error
+		if tuiutil.IsJSONFile(fileName) {
+			format := ExportFormats[ExportJSON]
+			if tuiutil.IsNDJSONFile(fileName) {
+				format = ExportFormats[ExportNDJSON]
+			}
+			err = WriteTableToFile(db, table, fileName, format.New())
+		} else {
+			err = WriteTableToCSVFile(db, table, fileName, TableView{}, tuiutil.CSVFormat.For(fileName))
+		}
+		if err != nil {
+			return strings.Join(written, ", "), fmt.Errorf("could not write %s to %s: %v", table, fileName, err)
+		}
+		written = append(written, fileName)
 	}
-	db.SetDatabaseReference(filename)
+	sort.Strings(written)
+
+	return strings.Join(written, ", "), nil
 }
@@ -0,0 +1,212 @@
+package viewer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	AppDirectoryName      = "termdbms"
+	SessionsDirectoryName = "sessions"
+	SessionLockFile       = "termdbms.lock"
+	SessionManifestFile   = "session.json"
+)
+
+// SessionDirectory holds the working copies and imported csv files of this process. Every session gets its own
+// directory under the cache directory with a lock file naming its process, so several termdbms can run side by side
+var SessionDirectory string
+
+// SessionManifest records what the working copy of a session was made from, so a crashed session can be tied
+// back to the files it was editing
+type SessionManifest struct {
+	Originals   []string  `json:"originals"` // the database, or the csv/json files
+	Base        string    `json:"base"`      // the file the working copy was made from, what its changes are against
+	WorkingCopy string    `json:"working_copy"`
+	Started     time.Time `json:"started"`
+}
+
+// CrashedSession is the directory of a session whose process went away without removing it. Sessions from
+// before manifests were written have none
+type CrashedSession struct {
+	Dir      string
+	PID      int
+	Started  time.Time
+	Files    []string
+	Manifest *SessionManifest
+}
+
+// GetCacheDirectory is $XDG_CACHE_HOME/termdbms (or the platform equivalent), the temp directory if there isn't one
+func GetCacheDirectory() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, AppDirectoryName)
+}
+
+// GetConfigDirectory is $XDG_CONFIG_HOME/termdbms (or the platform equivalent), for things kept across sessions
+func GetConfigDirectory() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return GetCacheDirectory()
+	}
+
+	return filepath.Join(dir, AppDirectoryName)
+}
+
+// StartSession makes the session directory and its lock file
+func StartSession() error {
+	root := filepath.Join(GetCacheDirectory(), SessionsDirectoryName)
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return fmt.Errorf("Could not create session directory: %v", err)
+	}
+	dir, err := os.MkdirTemp(root, fmt.Sprintf("%s-%d-", time.Now().Format("20060102-150405"), os.Getpid()))
+	if err != nil {
+		return fmt.Errorf("Could not create session directory: %v", err)
+	}
+	lock := filepath.Join(dir, SessionLockFile)
+	if err := os.WriteFile(lock, []byte(strconv.Itoa(os.Getpid())), 0o600); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("Could not create session lock: %v", err)
+	}
+	SessionDirectory = dir
+
+	return nil
+}
+
+// WriteSessionManifest records the working copy of this session and the files it belongs to
+func WriteSessionManifest(originals []string, base, workingCopy string) error {
+	manifest := SessionManifest{
+		Base:        base,
+		WorkingCopy: workingCopy,
+		Started:     time.Now(),
+	}
+	for _, o := range originals {
+		if abs, err := filepath.Abs(o); err == nil {
+			o = abs
+		}
+		manifest.Originals = append(manifest.Originals, o)
+	}
+	sort.Strings(manifest.Originals)
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(SessionDirectory, SessionManifestFile), b, 0o600)
+}
+
+// IsFor is true when the session was editing the same files
+func (s CrashedSession) IsFor(originals []string) bool {
+	if s.Manifest == nil || len(s.Manifest.Originals) != len(originals) {
+		return false
+	}
+	files := make([]string, len(originals))
+	for i, o := range originals {
+		if abs, err := filepath.Abs(o); err == nil {
+			o = abs
+		}
+		files[i] = o
+	}
+	sort.Strings(files)
+	for i, f := range files {
+		if s.Manifest.Originals[i] != f {
+			return false
+		}
+	}
+
+	return true
+}
+
+// EndSession removes the session directory, nothing in it is needed after a clean exit
+func EndSession() {
+	if SessionDirectory == "" {
+		return
+	}
+	os.RemoveAll(SessionDirectory)
+	SessionDirectory = ""
+}
+
+// GetCrashedSessions lists the sessions whose process is gone, other than this one. Their files are left for
+// recovery, only sessions that never got further than their lock file are cleaned up
+func GetCrashedSessions() ([]CrashedSession, error) {
+	root := filepath.Join(GetCacheDirectory(), SessionsDirectoryName)
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var sessions []CrashedSession
+	for _, e := range entries {
+		dir := filepath.Join(root, e.Name())
+		if !e.IsDir() || dir == SessionDirectory {
+			continue
+		}
+		lockFile := filepath.Join(dir, SessionLockFile)
+		lock, err := os.ReadFile(lockFile)
+		if err != nil { // still starting up, or not a session
+			continue
+		}
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(lock)))
+		if isProcessRunning(pid) {
+			continue
+		}
+
+		session := CrashedSession{
+			Dir: dir,
+			PID: pid,
+		}
+		if info, err := os.Stat(lockFile); err == nil {
+			session.Started = info.ModTime()
+		}
+		files, _ := os.ReadDir(dir)
+		for _, f := range files {
+			if f.Name() != SessionLockFile && f.Name() != SessionManifestFile {
+				session.Files = append(session.Files, filepath.Join(dir, f.Name()))
+			}
+		}
+		if b, err := os.ReadFile(filepath.Join(dir, SessionManifestFile)); err == nil {
+			var manifest SessionManifest
+			if json.Unmarshal(b, &manifest) == nil && manifest.WorkingCopy != "" {
+				session.Manifest = &manifest
+			}
+		}
+		if len(session.Files) == 0 {
+			os.RemoveAll(dir)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// isProcessRunning errs on the side of running, a session is only treated as crashed when its process is surely gone
+func isProcessRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil { // windows looks the process up here
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -1,8 +1,11 @@
 package viewer
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -15,6 +18,25 @@ var (
 	style = lipgloss.NewStyle()
 )
 
+// GetSnippetsFile is where the clipboard of SQL snippets is kept, in the config directory
+func GetSnippetsFile() string {
+	return filepath.Join(GetConfigDirectory(), SQLSnippetsFile)
+}
+
+// WriteSnippets saves the clipboard for later sessions
+func WriteSnippets(snippets []list.Item) (string, error) {
+	snippetsFile := GetSnippetsFile()
+	b, err := json.Marshal(snippets)
+	if err != nil {
+		return snippetsFile, err
+	}
+	if err = os.MkdirAll(filepath.Dir(snippetsFile), 0o755); err != nil {
+		return snippetsFile, err
+	}
+
+	return snippetsFile, os.WriteFile(snippetsFile, b, 0o644)
+}
+
 func (s SQLSnippet) Title() string {
 	return s.Name
 }
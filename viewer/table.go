@@ -26,7 +26,7 @@ func init() {
 	MIP = false
 	mid = &tmp
 	HeaderAssembly = func(m *TuiModel, s *string, done *chan bool) {
-		if m.UI.ShowClipboard {
+		if m.IsListShown() {
 			*done <- true
 			return
 		}
@@ -49,7 +49,7 @@ func init() {
 				continue
 			}
 
-			text := " " + TruncateIfApplicable(m, d)
+			text := " " + TruncateIfApplicable(m, m.GetSortMarker(d)+d)
 			builder = append(builder, style.
 				Render(text))
 		}
@@ -64,12 +64,34 @@ func init() {
 					headerTop = HeaderStyle.Copy().Faint(true).Render(headerTop)
 				}
 			} else {
-				headerTop = fmt.Sprintf(" %s (%d/%d) - %d record(s) + %d column(s)",
+				headerTop = fmt.Sprintf(" %s (%d/%d) - %s record(s) + %d column(s)",
 					m.GetSchemaName(),
 					m.UI.CurrentTable,
 					len(m.Data().TableHeaders), // look at how headers get rendered to get accurate record number
-					len(m.GetColumnData()),
-					len(m.GetHeaders())) // this will need to be refactored when filters get added
+					m.GetRowCountString(),
+					len(m.GetHeaders()))
+				if schemas := m.GetDatabaseSchemas(); len(schemas) > 0 { // schema > table for databases that have them
+					schema, table := database.SplitTableName(m.Table().Database, m.GetSchemaName())
+					tables := m.GetTablesForDatabaseSchema(schema)
+					headerTop = fmt.Sprintf(" %s (%d/%d) > %s (%d/%d) - %s record(s) + %d column(s)",
+						schema,
+						indexOf(schemas, schema)+1,
+						len(schemas),
+						table,
+						m.UI.CurrentTable-tables[0]+1,
+						len(tables),
+						m.GetRowCountString(),
+						len(m.GetHeaders()))
+				}
+				if view := m.GetTableView().Describe(); view != "" && m.QueryResult == nil {
+					headerTop += " | " + view
+				}
+				if m.IsReadOnlyTable() {
+					headerTop += " | view (read only)"
+				}
+				if m.Search.Term != "" && m.QueryResult == nil {
+					headerTop += " | search: " + m.Search.Term
+				}
 				headerTop = HeaderStyle.Render(headerTop)
 			}
 
@@ -83,7 +105,7 @@ func init() {
 		*done <- true
 	}
 	FooterAssembly = func(m *TuiModel, s *string, done *chan bool) {
-		if m.UI.ShowClipboard {
+		if m.IsListShown() {
 			*done <- true
 			return
 		}
@@ -102,13 +124,13 @@ func init() {
 		if m.UI.RenderSelection {
 			footer = ""
 		}
-		undoRedoInfo := fmt.Sprintf(" undo(%d) / redo(%d) ", len(m.UndoStack), len(m.RedoStack))
-		switch m.Table().Database.(type) {
-		case *database.SQLite:
-			break
-		default:
-			undoRedoInfo = ""
-			break
+		undoRedoInfo := ""
+		if m.Query != nil { // the journal is in use by the statement
+			undoRedoInfo = m.Query.Status()
+		} else if m.Journal != nil {
+			undoRedoInfo = fmt.Sprintf(" undo(%d) / redo(%d) ", len(m.Journal.UndoStack), len(m.Journal.RedoStack))
+		} else if database.ReadOnly {
+			undoRedoInfo = " READ ONLY "
 		}
 
 		gapSize := m.Viewport.Width - lipgloss.Width(footer) - lipgloss.Width(undoRedoInfo) - 2
@@ -1,9 +1,10 @@
 package viewer
 
 import (
-	"errors"
+	"fmt"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mathaou/termdbms/database"
 	"github.com/mathaou/termdbms/tuiutil"
 )
 
@@ -11,9 +12,24 @@ var maxHeaders int
 
 // AssembleTable shows either the selection text or the table
 func AssembleTable(m *TuiModel) string {
+	if m.Confirmation != nil {
+		return DisplayConfirmation(m)
+	}
 	if m.UI.ShowClipboard {
 		return ShowClipboard(m)
 	}
+	if m.UI.ShowSearchResults {
+		return ShowSearchResults(m)
+	}
+	if m.UI.ShowSchema {
+		return ShowSchema(m)
+	}
+	if m.UI.ShowChanges {
+		return ShowChanges(m)
+	}
+	if m.UI.ShowHistory {
+		return ShowHistory(m)
+	}
 	if m.UI.RenderSelection {
 		return DisplaySelection(m)
 	}
@@ -98,6 +114,37 @@ func (m *TuiModel) GetSchemaName() string {
 	return m.Data().TableIndexMap[m.UI.CurrentTable]
 }
 
+// GetDatabaseSchemas returns the distinct schemas that own the tables in TableIndexMap, in table order.
+// Databases without schemas (SQLite, MySQL) return nothing
+func (m *TuiModel) GetDatabaseSchemas() []string {
+	var schemas []string
+	d := m.Data()
+	for i := 1; i <= len(d.TableIndexMap); i++ {
+		schema, _ := database.SplitTableName(m.Table().Database, d.TableIndexMap[i])
+		if schema == "" {
+			continue
+		}
+		if len(schemas) == 0 || schemas[len(schemas)-1] != schema {
+			schemas = append(schemas, schema)
+		}
+	}
+
+	return schemas
+}
+
+// GetTablesForDatabaseSchema returns the TableIndexMap indices of every table in the given schema
+func (m *TuiModel) GetTablesForDatabaseSchema(schema string) []int {
+	var tables []int
+	d := m.Data()
+	for i := 1; i <= len(d.TableIndexMap); i++ {
+		if s, _ := database.SplitTableName(m.Table().Database, d.TableIndexMap[i]); s == schema {
+			tables = append(tables, i)
+		}
+	}
+
+	return tables
+}
+
 // GetHeaders does just that for the current schema
 func (m *TuiModel) GetHeaders() []string {
 	schema := m.GetSchemaName()
@@ -135,15 +182,18 @@ func (m *TuiModel) SetViewSlices() {
 		}
 		// data slices
 		defer func() {
-			if recover() != nil {
-				panic(errors.New("adsf"))
+			if r := recover(); r != nil { // show what went wrong instead of taking the terminal down with it
+				m.DisplayMessage(fmt.Sprintf("Could not show %s: %v", m.GetSchemaName(), r))
 			}
 		}()
 
+		if !m.UI.RenderSelection {
+			m.EnsurePageLoaded()
+		}
 		for _, columnName := range headers {
 			interfaceValues := m.GetSchemaData()[columnName]
 			if len(interfaceValues) >= m.Viewport.Height {
-				min := Min(m.Viewport.YOffset, len(interfaceValues)-m.Viewport.Height)
+				min := m.GetViewSliceStart(len(interfaceValues))
 
 				d.TableSlices[columnName] = interfaceValues[min : m.Viewport.Height+min]
 			} else {
@@ -156,6 +206,15 @@ func (m *TuiModel) SetViewSlices() {
 	// format slices
 }
 
+// GetViewSliceStart is the row of the loaded page the viewport starts at, for a page of length rows
+func (m *TuiModel) GetViewSliceStart(length int) int {
+	if length < m.Viewport.Height {
+		return 0
+	}
+
+	return Min(Max(m.Viewport.YOffset-m.GetPageOffset(), 0), length-m.Viewport.Height)
+}
+
 // GetSchemaData is a helper function to get the data of the current schema
 func (m *TuiModel) GetSchemaData() map[string][]interface{} {
 	n := m.GetSchemaName()
@@ -194,8 +253,12 @@ func (m *TuiModel) GetRowData() map[string]interface{} {
 	headers := m.GetHeaders()
 	schema := m.GetSchemaData()
 	data := make(map[string]interface{})
+	row := m.GetRow() - m.GetPageOffset()
 	for _, v := range headers {
-		data[v] = schema[v][m.GetRow()]
+		data[v] = schema[v][row]
+	}
+	if rowIDs, ok := schema[database.RowIDColumn]; ok {
+		data[database.RowIDColumn] = rowIDs[row]
 	}
 
 	return data
@@ -208,10 +271,11 @@ func (m *TuiModel) GetSelectedOption() (*interface{}, int, []interface{}) {
 	}
 	row := m.GetRow()
 	col := m.GetColumnData()
-	if row >= len(col) {
+	index := row - m.GetPageOffset() // col only holds the loaded page
+	if index < 0 || index >= len(col) {
 		return nil, row, col
 	}
-	return &col[row], row, col
+	return &col[index], row, col
 }
 
 func (m *TuiModel) DisplayMessage(msg string) {
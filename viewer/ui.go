@@ -109,6 +109,7 @@ func DisplayTable(m *TuiModel) string {
 		)
 
 		columnValues := m.Data().TableSlices[columnName]
+		start := m.GetViewSliceStart(pageLength(m.GetSchemaData()))
 		for r, val := range columnValues {
 			base := m.GetBaseStyle().
 				UnsetBorderLeft().
@@ -117,6 +118,20 @@ func DisplayTable(m *TuiModel) string {
 			s := GetStringRepresentationOfInterface(val)
 			s = " " + s
 			// handle highlighting
+			if m.IsSearchMatch(s) {
+				if !tuiutil.Ascii {
+					base = base.Reverse(true)
+				} else {
+					s = "*" + s
+				}
+			}
+			if m.IsChangedCell(start+r, columnName) {
+				if !tuiutil.Ascii {
+					base = base.Underline(true)
+				} else {
+					s = "~" + s
+				}
+			}
 			if c == m.GetColumn() && r == m.GetRow() {
 				if !tuiutil.Ascii {
 					base.Foreground(lipgloss.Color(tuiutil.Highlight()))
@@ -256,7 +271,11 @@ func DisplaySelection(m *TuiModel) string {
 	}
 
 	var prettyPrint string
-	raw := col[row]
+	index := row - m.GetPageOffset()
+	if index < 0 || index >= len(col) {
+		return DisplayTable(m)
+	}
+	raw := col[index]
 
 	if conv, ok := raw.(int64); ok {
 		prettyPrint = strconv.Itoa(int(conv))
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"hash/fnv"
 	"io"
+	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -16,10 +17,12 @@ import (
 	"time"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mathaou/termdbms/database"
+	"github.com/mathaou/termdbms/tuiutil"
 )
 
 const (
-	HiddenTmpDirectoryName = ".termdbms"
+	HiddenTmpDirectoryName = ".termdbms" // where older versions kept their files, in the directory they were started from
 	SQLSnippetsFile        = "snippets.termdbms"
 )
 
@@ -72,6 +75,11 @@ func GetInterfaceFromString(str string, original *interface{}) interface{} {
 		return t // TODO figure out how to handle things like time and date
 	case string:
 		return str
+	case nil: // NULL cells take whatever gets typed into them
+		if str == "NULL" {
+			return nil
+		}
+		return str
 	}
 
 	return nil
@@ -91,6 +99,8 @@ func GetStringRepresentationOfInterface(val interface{}) string {
 	} else if t, ok := val.(time.Time); ok {
 		str := t.String()
 		return str
+	} else if b, ok := val.(bool); ok {
+		return strconv.FormatBool(b)
 	} else if val == nil {
 		return "NULL"
 	}
@@ -98,52 +108,168 @@ func GetStringRepresentationOfInterface(val interface{}) string {
 	return ""
 }
 
-func WriteCSV(m *TuiModel) { // basically display table but without any styling
-	if m.QueryData == nil || m.QueryResult == nil {
-		return // should never happen but just making sure
+// WriteTableToCSVFile writes the rows of a table that make it through the filter of view to fileName, in its order
+func WriteTableToCSVFile(db database.Database, tableName, fileName string, view TableView, format tuiutil.CSVOptions) error {
+	query := fmt.Sprintf("select * from %s%s", database.QuoteTableName(db, tableName), view.WhereClause())
+	if order := view.OrderTerms(db, nil); len(order) > 0 {
+		query += " order by " + strings.Join(order, ", ")
+	}
+	c, err := db.GetDatabaseReference().Query(query)
+	if err != nil {
+		return err
 	}
+	defer c.Close()
+
+	return ReplaceFile(fileName, func(w *bufio.Writer) error {
+		columnNames, _ := c.Columns()
+		typeNames := make([]string, len(columnNames))
+		if types, err := c.ColumnTypes(); err == nil {
+			for i, t := range types {
+				typeNames[i] = t.DatabaseTypeName()
+			}
+		}
+		if !format.NoHeader {
+			w.WriteString(tuiutil.FormatCSVRecord(columnNames, format) + "\n")
+		}
+		for c.Next() {
+			columns := make([]interface{}, len(columnNames))
+			columnPointers := make([]interface{}, len(columnNames))
+			r := make([]string, len(columnNames))
+			for i := range columns {
+				columnPointers[i] = &columns[i]
+			}
+			c.Scan(columnPointers...)
+			for i, v := range columns {
+				r[i] = GetCSVRepresentationOfColumn(v, typeNames[i])
+			}
+			w.WriteString(tuiutil.FormatCSVRecord(r, format) + "\n")
+		}
 
-	var (
-		builder [][]string
-		buffer  strings.Builder
-	)
+		return c.Err()
+	})
+}
 
-	d := m.Data()
+// ReplaceFile writes fileName through a temp file next to it, which only takes its place once write succeeded.
+// A failure halfway leaves the old file as it was. The new file gets the permissions of the one it replaces
+func ReplaceFile(fileName string, write func(w *bufio.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // gone already if it was renamed
 
-	// go through all columns
-	for _, columnName := range d.TableHeaders[QueryResultsTableName] {
-		var (
-			rowBuilder []string
-		)
+	w := bufio.NewWriter(tmp)
+	if err = write(w); err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(GetFileMode(fileName, 0o644))
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fileName)
+}
 
-		columnValues := m.GetSchemaData()[columnName]
-		rowBuilder = append(rowBuilder, columnName)
-		for _, val := range columnValues {
-			s := GetStringRepresentationOfInterface(val)
-			// display text based on type
-			rowBuilder = append(rowBuilder, s)
+// GetFileMode is the permissions of fileName, or mode if it doesn't exist
+func GetFileMode(fileName string, mode os.FileMode) os.FileMode {
+	if info, err := os.Stat(fileName); err == nil {
+		return info.Mode().Perm()
+	}
+
+	return mode
+}
+
+// GetJSONRepresentationOfInterface is a value as it goes into json. Booleans and json text are stored by the
+// json importer in columns declared BOOLEAN and JSON, they're turned back into what they were. Floats keep a
+// decimal point, so they're read back as REAL
+func GetJSONRepresentationOfInterface(val interface{}, typeName string) interface{} {
+	if b, ok := val.([]byte); ok {
+		val = string(b)
+	}
+
+	switch conv := val.(type) {
+	case int64:
+		if typeName == "BOOLEAN" {
+			return conv != 0
+		}
+	case string:
+		if typeName == "JSON" && json.Valid([]byte(conv)) {
+			return json.RawMessage(conv)
 		}
-		builder = append(builder, rowBuilder)
+	case float64:
+		return getJSONFloat(conv, 64)
+	case float32:
+		return getJSONFloat(float64(conv), 32)
+	case time.Time:
+		return GetCSVRepresentationOfInterface(conv)
+	}
+
+	return val
+}
+
+// getJSONFloat writes f the way encoding/json does, with .0 added to whole numbers
+func getJSONFloat(f float64, bits int) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return f // json has no such numbers, let encoding/json say so
 	}
 
-	depth := len(builder[0])
-	headers := len(builder)
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		return json.Number(strconv.FormatFloat(f, 'e', -1, bits))
+	}
+	s := strconv.FormatFloat(f, 'f', -1, bits)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+
+	return json.Number(s)
+}
 
-	for i := 0; i < depth; i++ {
-		var r []string
-		for x := 0; x < headers; x++ {
-			r = append(r, builder[x][i])
+// GetCSVRepresentationOfInterface is the text of a value as the csv importer reads it back, so nothing gets
+// rounded and NULL stays empty
+func GetCSVRepresentationOfInterface(val interface{}) string {
+	switch conv := val.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(conv)
+	case float64:
+		return strconv.FormatFloat(conv, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(conv), 'f', -1, 32)
+	case time.Time:
+		return tuiutil.FormatCSVTime(conv)
+	}
+
+	return GetStringRepresentationOfInterface(val)
+}
+
+// GetCSVRepresentationOfColumn is GetCSVRepresentationOfInterface for a value of a column declared typeName. Numbers
+// in a DECIMAL(p,s) column keep s decimals, the way the csv importer read them
+func GetCSVRepresentationOfColumn(val interface{}, typeName string) string {
+	if scale, ok := tuiutil.GetDecimalScale(typeName); ok {
+		switch conv := val.(type) {
+		case int64:
+			return tuiutil.FormatCSVDecimal(strconv.FormatInt(conv, 10), scale)
+		case float64:
+			return tuiutil.FormatCSVDecimal(strconv.FormatFloat(conv, 'f', -1, 64), scale)
 		}
-		buffer.WriteString(strings.Join(r, ","))
-		buffer.WriteString("\n")
 	}
 
-	WriteTextFile(m, buffer.String())
+	return GetCSVRepresentationOfInterface(val)
 }
 
-func WriteTextFile(m *TuiModel, text string) (string, error) {
+func GetTextFileName(m *TuiModel) string {
 	rand.Seed(time.Now().Unix())
-	fileName := m.GetSchemaName() + "_" + "renderView_" + fmt.Sprintf("%d", rand.Int()) + ".txt"
+	return m.GetSchemaName() + "_" + "renderView_" + fmt.Sprintf("%d", rand.Int()) + ".txt"
+}
+
+func WriteTextFile(m *TuiModel, text string) (string, error) {
+	fileName := GetTextFileName(m)
 	e := os.WriteFile(fileName, []byte(text), 0777)
 	return fileName, e
 }
@@ -200,7 +326,7 @@ func GetScrollDownMaximumForSelection(m *TuiModel) int {
 	} else if m.UI.FormatModeEnabled {
 		max = len(SplitLines(DisplayFormatText(m)))
 	} else {
-		return len(m.GetColumnData())
+		return m.GetRowCount()
 	}
 
 	return max
@@ -257,20 +383,26 @@ func CopyFile(src string) (string, int64, error) {
 	}
 	defer source.Close()
 
-	destination, err := os.CreateTemp(HiddenTmpDirectoryName, dst)
+	destination, err := os.CreateTemp(SessionDirectory, dst)
 	if err != nil {
 		return "", 0, err
 	}
 	defer destination.Close()
 	nBytes, err := io.Copy(destination, source)
-	info, _ := destination.Stat()
-	path, _ := filepath.Abs(
-		fmt.Sprintf("%s/%s",
-			HiddenTmpDirectoryName,
-			info.Name())) // platform agnostic
+	path, _ := filepath.Abs(destination.Name())
 	return path, nBytes, err
 }
 
+func indexOf(slice []string, s string) int {
+	for i, v := range slice {
+		if v == s {
+			return i
+		}
+	}
+
+	return -1
+}
+
 // MATH YO
 
 func Min(a, b int) int {
@@ -3,6 +3,7 @@ package viewer
 import (
 	"fmt"
 
+	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/mathaou/termdbms/list"
@@ -27,6 +28,11 @@ func (m *TuiModel) Data() *UIData {
 	return &m.DefaultData
 }
 
+// IsListShown is true when one of the full screen lists (clipboard, search results, schema, changes, history) replaces the table
+func (m *TuiModel) IsListShown() bool {
+	return m.UI.ShowClipboard || m.UI.ShowSearchResults || m.UI.ShowSchema || m.UI.ShowChanges || m.UI.ShowHistory
+}
+
 func (m *TuiModel) Table() *TableState {
 	if m.QueryResult != nil {
 		return m.QueryResult
@@ -60,7 +66,7 @@ func SetStyles() {
 func (m TuiModel) Init() tea.Cmd {
 	SetStyles()
 
-	return nil
+	return m.CountRows()
 }
 
 // Update is where all commands and whatnot get processed
@@ -76,9 +82,25 @@ func (m TuiModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := message.(type) {
 	case list.FilterMatchesMessage:
+		if m.UI.ShowSearchResults {
+			m.Search.Results, command = m.Search.Results.Update(msg)
+			break
+		} else if m.UI.ShowSchema {
+			m.SchemaList, command = m.SchemaList.Update(msg)
+			break
+		} else if m.UI.ShowChanges {
+			m.Changes.Panel, command = m.Changes.Panel.Update(msg)
+			break
+		} else if m.UI.ShowHistory {
+			m.History.List, command = m.History.List.Update(msg)
+			break
+		}
 		m.ClipboardList, command = m.ClipboardList.Update(msg)
 		break
 	case tea.MouseMsg:
+		if m.Confirmation != nil || m.Query != nil {
+			break
+		}
 		HandleMouseEvents(&m, &msg)
 		m.SetViewSlices()
 		break
@@ -90,10 +112,48 @@ func (m TuiModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 		break
 	case tea.KeyMsg:
 		str := msg.String()
+		if m.Query != nil { // the connection is busy until the statement is done
+			if str == "esc" || str == "ctrl+c" {
+				m.CancelQuery()
+			}
+			break
+		}
+		if m.Confirmation != nil && str != "ctrl+c" {
+			HandleConfirmationEvents(&m, str)
+			if m.Confirmation == nil && !m.UI.EditModeEnabled && !m.IsListShown() && m.Ready {
+				m.SetViewSlices() // the answer may have changed the data
+			}
+			break
+		}
 		if m.UI.ShowClipboard {
 			HandleClipboardEvents(&m, str, &command, msg)
 			break
 		}
+		if m.UI.ShowSearchResults {
+			HandleSearchResultEvents(&m, str, &command, msg)
+			if !m.UI.ShowSearchResults {
+				m.SetViewSlices()
+			}
+			break
+		}
+		if m.UI.ShowSchema {
+			HandleSchemaEvents(&m, str, &command, msg)
+			break
+		}
+		if m.UI.ShowHistory {
+			HandleHistoryEvents(&m, str, &command, msg)
+			if !m.UI.ShowHistory && !m.UI.FormatModeEnabled {
+				m.SetViewSlices()
+			}
+			break
+		}
+		if m.UI.ShowChanges {
+			HandleChangesEvents(&m, str, &command, msg)
+			if !m.UI.ShowChanges {
+				m.SetViewSlices()
+			}
+			break
+		}
 
 		// when fullscreen selection viewing is in session, don't allow UI manipulation other than quit or exit
 		s := msg.String()
@@ -123,6 +183,22 @@ func (m TuiModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 
+		break
+	case spinner.TickMsg:
+		if m.Query != nil {
+			var tick tea.Cmd
+			m.Query.Spinner, tick = m.Query.Spinner.Update(msg)
+			commands = append(commands, tick)
+		}
+		break
+	case QueryDoneMsg:
+		m.FinishQuery(msg)
+		if m.Query == nil && !m.UI.EditModeEnabled && !m.IsListShown() && m.Ready {
+			m.SetViewSlices()
+		}
+		break
+	case RowCountMsg:
+		msg.Counts[msg.Table] = msg.Count
 		break
 	case error:
 		return m, nil
@@ -131,6 +207,12 @@ func (m TuiModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 	if m.Viewport.HighPerformanceRendering {
 		commands = append(commands, command)
 	}
+	if count := m.CountRows(); count != nil { // the current table, if it was switched to or (re)loaded
+		commands = append(commands, count)
+	}
+	if query := m.RunQuery(); query != nil { // a statement queued by SQL mode
+		commands = append(commands, query)
+	}
 
 	return m, tea.Batch(commands...)
 }
@@ -153,7 +235,7 @@ func (m TuiModel) View() string {
 		done <- true
 	}(&content)
 
-	if m.UI.ShowClipboard {
+	if m.IsListShown() {
 		<-done
 		return content
 	}